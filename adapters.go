@@ -0,0 +1,23 @@
+package prommonitoring
+
+import "net/http"
+
+// Middleware and RecoverMiddleware have the func(http.Handler) http.Handler
+// shape expected by net/http chains, alice (alice.Constructor), chi
+// (Router.Use) and gorilla/mux (Router.Use), and can be passed as method
+// values, e.g. alice.New(m.RecoverMiddleware, m.Middleware).
+
+// NegroniMiddleware adapts Middleware to the negroni handler signature.
+// The method value m.NegroniMiddleware is a negroni.HandlerFunc:
+//
+//	n := negroni.New()
+//	n.Use(negroni.HandlerFunc(m.NegroniMiddleware))
+func (m *Metrics) NegroniMiddleware(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	m.Middleware(next).ServeHTTP(w, r)
+}
+
+// NegroniRecoverMiddleware adapts RecoverMiddleware to the negroni handler
+// signature
+func (m *Metrics) NegroniRecoverMiddleware(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	m.RecoverMiddleware(next).ServeHTTP(w, r)
+}
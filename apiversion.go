@@ -0,0 +1,31 @@
+package prommonitoring
+
+import (
+	"net/http"
+	"slices"
+)
+
+// UnknownAPIVersion is the api_version label of requests whose version
+// couldn't be extracted or isn't in Config.APIVersions
+const UnknownAPIVersion = "unknown"
+
+// apiVersion extracts the API version token from the configured header,
+// e.g. "v2" from "Accept: application/vnd.myapp.v2+json". Only versions in
+// the allowlist are returned, so clients can't create arbitrary series.
+func (m *Metrics) apiVersion(r *http.Request) string {
+	header := m.cfg.APIVersionHeader
+	if header == "" {
+		header = "Accept"
+	}
+
+	for _, value := range r.Header.Values(header) {
+		match := m.cfg.APIVersionPattern.FindStringSubmatch(value)
+		if len(match) < 2 {
+			continue
+		}
+		if slices.Contains(m.cfg.APIVersions, match[1]) {
+			return match[1]
+		}
+	}
+	return UnknownAPIVersion
+}
@@ -0,0 +1,28 @@
+package prommonitoring
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// maxAttemptCount caps observed attempt counts, so a bogus header value
+// lands in the last bucket instead of skewing the sum
+const maxAttemptCount = 20
+
+// attemptCountBuckets are the buckets of http_upstream_attempt_count
+var attemptCountBuckets = []float64{1, 2, 3, 4, 5, 10, maxAttemptCount}
+
+// observeAttemptCount records the numeric value of
+// Config.AttemptCountHeader. Missing and non-numeric values are skipped.
+func (m *Metrics) observeAttemptCount(r *http.Request, info *requestInfo) {
+	value := strings.TrimSpace(r.Header.Get(m.cfg.AttemptCountHeader))
+	if value == "" {
+		return
+	}
+	count, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return
+	}
+	m.UpstreamAttempts.WithLabelValues(m.labelValues(m.pathLabels, info)...).Observe(float64(min(count, maxAttemptCount)))
+}
@@ -0,0 +1,30 @@
+package prommonitoring
+
+import (
+	"context"
+	"time"
+)
+
+// RecordBackendLatency adds d to the time the request spent waiting on
+// backends, recorded in http_backend_duration_seconds with
+// Config.TrackBackendDuration. Handlers call it around their upstream
+// calls; the latencies of several calls add up. Subtracting it from
+// http_request_duration_seconds gives the service's own time. It does
+// nothing outside Middleware.
+func RecordBackendLatency(ctx context.Context, d time.Duration) {
+	if state := requestStateFromContext(ctx); state != nil {
+		state.backendLatency.Add(int64(d))
+		state.backendReported.Store(true)
+	}
+}
+
+// observeBackendLatency records the backend latency reported for the
+// request, if any
+func (m *Metrics) observeBackendLatency(t *trackedRequest, info *requestInfo) {
+	state := requestStateFromContext(t.request.Context())
+	if !state.backendReported.Load() {
+		return
+	}
+	d := time.Duration(state.backendLatency.Load())
+	m.BackendDuration.WithLabelValues(m.labelValues(m.routeLabels, info)...).Observe(d.Seconds())
+}
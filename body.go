@@ -0,0 +1,126 @@
+package prommonitoring
+
+import (
+	"io"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// countingBody wraps a request body to record how many bytes the handler
+// actually read and when reading started and ended. The state is guarded
+// by mu because a handler abandoned by TimeoutMiddleware may still be
+// reading while the request is recorded.
+type countingBody struct {
+	io.ReadCloser
+	now func() time.Time
+
+	mu        sync.Mutex
+	bytesRead int64
+	firstRead time.Time
+	lastRead  time.Time
+	readErr   error
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	b.mu.Lock()
+	if b.firstRead.IsZero() {
+		b.firstRead = b.now()
+	}
+	b.mu.Unlock()
+
+	n, err := b.ReadCloser.Read(p)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.bytesRead += int64(n)
+	if n > 0 || err == io.EOF {
+		b.lastRead = b.now()
+	}
+	if err != nil && err != io.EOF && b.readErr == nil {
+		b.readErr = err
+	}
+	return n, err
+}
+
+// readDuration returns the time between the first and the last read, and
+// false if the body was never read
+func (b *countingBody) readDuration() (time.Duration, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.firstRead.IsZero() || b.lastRead.IsZero() {
+		return 0, false
+	}
+	return max(b.lastRead.Sub(b.firstRead), 0), true
+}
+
+// bytesReadSoFar returns the number of bytes read
+func (b *countingBody) bytesReadSoFar() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.bytesRead
+}
+
+// err returns the first read error other than io.EOF
+func (b *countingBody) err() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.readErr
+}
+
+// wrapBody installs a countingBody on the request when a body-based
+// feature is enabled, and returns nil otherwise
+func (m *Metrics) wrapBody(r *http.Request) *countingBody {
+	if !m.wrapsBody() || r.Body == nil || r.Body == http.NoBody {
+		return nil
+	}
+	body := &countingBody{ReadCloser: r.Body, now: m.now}
+	r.Body = body
+	return body
+}
+
+// wrapsBody reports whether a feature needs the counting body reader
+func (m *Metrics) wrapsBody() bool {
+	return m.RequestBodyReadDuration != nil || m.cfg.RequestSizeFromBody || m.cfg.CountBodyReadErrors
+}
+
+// observeBody records the body metrics once the handler returned
+func (m *Metrics) observeBody(body *countingBody, info *requestInfo) {
+	if m.RequestBodyReadDuration != nil {
+		if d, ok := body.readDuration(); ok {
+			m.RequestBodyReadDuration.WithLabelValues(m.labelValues(m.routeLabels, info)...).Observe(d.Seconds())
+		}
+	}
+
+	// A failed read is an error whatever status the handler sent
+	if m.cfg.CountBodyReadErrors && body.err() != nil {
+		errInfo := *info
+		errInfo.errorType = "request_read_error"
+		m.TotalErrors.WithLabelValues(m.labelValues(m.errorLabels, &errInfo)...).Inc()
+	}
+}
+
+// DefaultBodyMethods returns the methods that typically carry a request
+// body, for Config.RequestSizeMethods
+func DefaultBodyMethods() []string {
+	return []string{http.MethodPost, http.MethodPut, http.MethodPatch}
+}
+
+// requestSize returns the request size to observe, and false if none
+// should be. It is the number of bytes the handler read with
+// Config.RequestSizeFromBody, and the declared Content-Length otherwise.
+func (m *Metrics) requestSize(r *http.Request, body *countingBody) (int64, bool) {
+	if m.cfg.RequestSizeMethods != nil && !slices.Contains(m.cfg.RequestSizeMethods, r.Method) {
+		return 0, false
+	}
+
+	size := r.ContentLength
+	if m.cfg.RequestSizeFromBody {
+		size = 0
+		if body != nil {
+			size = body.bytesReadSoFar()
+		}
+	}
+	return size, size > 0
+}
@@ -0,0 +1,114 @@
+package prommonitoring
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"sync/atomic"
+)
+
+// MaxBodyMiddleware limits request bodies to limit bytes like
+// http.MaxBytesReader, answering 413 Request Entity Too Large when the
+// limit is exceeded and counting it in http_request_body_too_large_total
+// with Config.CountBodyTooLarge. Requests declaring a larger
+// Content-Length are rejected before next runs. Otherwise next sees the
+// read error, and whatever status it then sends is replaced with 413;
+// if it sends nothing, the 413 is sent once it returned. Wrap
+// MaxBodyMiddleware with Middleware so the request is recorded with 413.
+func (m *Metrics) MaxBodyMiddleware(limit int64, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > limit {
+			m.rejectBodyTooLarge(w, r)
+			return
+		}
+
+		bw := &bodyLimitWriter{ResponseWriter: w}
+		if r.Body != nil && r.Body != http.NoBody {
+			r2 := *r
+			r2.Body = &bodyLimitReader{ReadCloser: http.MaxBytesReader(w, r.Body, limit), exceeded: &bw.exceeded}
+			r = &r2
+		}
+		next.ServeHTTP(bw, r)
+
+		if bw.exceeded.Load() {
+			m.countBodyTooLarge(r)
+			if !bw.wroteHeader {
+				http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+			}
+		}
+	})
+}
+
+// rejectBodyTooLarge answers 413 without running the handler
+func (m *Metrics) rejectBodyTooLarge(w http.ResponseWriter, r *http.Request) {
+	m.countBodyTooLarge(r)
+	http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+}
+
+func (m *Metrics) countBodyTooLarge(r *http.Request) {
+	if m.BodyTooLarge != nil {
+		info := &requestInfo{request: r, method: r.Method, path: m.pathLabel(r)}
+		m.BodyTooLarge.WithLabelValues(m.labelValues(m.routeLabels, info)...).Inc()
+	}
+}
+
+// bodyLimitReader notes when the body of a request exceeds the limit
+type bodyLimitReader struct {
+	io.ReadCloser
+	exceeded *atomic.Bool
+}
+
+func (b *bodyLimitReader) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		b.exceeded.Store(true)
+	}
+	return n, err
+}
+
+// bodyLimitWriter replaces the status of the response with 413 once the
+// request body exceeded the limit
+type bodyLimitWriter struct {
+	http.ResponseWriter
+	exceeded    atomic.Bool
+	wroteHeader bool
+}
+
+func (w *bodyLimitWriter) WriteHeader(statusCode int) {
+	// Informational responses precede the final header
+	if statusCode >= 100 && statusCode < 200 && statusCode != http.StatusSwitchingProtocols {
+		w.ResponseWriter.WriteHeader(statusCode)
+		return
+	}
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	if w.exceeded.Load() {
+		statusCode = http.StatusRequestEntityTooLarge
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *bodyLimitWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush sends the headers, so the status is settled first
+func (w *bodyLimitWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap returns the underlying writer for http.ResponseController
+func (w *bodyLimitWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,171 @@
+package prommonitoring
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DefaultDurationBuckets returns the buckets used for the request duration
+// histogram when Config.DurationBuckets is unset
+func DefaultDurationBuckets() []float64 {
+	return []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+}
+
+// PrometheusDefBuckets returns a copy of the Prometheus client's default
+// buckets
+func PrometheusDefBuckets() []float64 {
+	return append([]float64(nil), prometheus.DefBuckets...)
+}
+
+// LinearLatencyBuckets returns count buckets, the lowest with upper bound
+// start and each following one width wider. It panics if count is less
+// than 1 or width is not positive.
+func LinearLatencyBuckets(start, width float64, count int) []float64 {
+	if width <= 0 {
+		panic("LinearLatencyBuckets needs a positive width")
+	}
+	return prometheus.LinearBuckets(start, width, count)
+}
+
+// sloBucketFactors are the bucket boundaries of LatencyBucketsForSLO,
+// relative to the objective. They are densest around 1 so that
+// histogram_quantile is most accurate where the SLO is evaluated.
+var sloBucketFactors = []float64{
+	0.1, 0.25, 0.5, 0.75, 0.9, 0.95, 1, 1.05, 1.1, 1.25, 1.5, 2, 3, 5, 10,
+}
+
+// LatencyBucketsForSLO returns duration buckets placed around a latency
+// objective in seconds, with one boundary exactly at the objective and
+// dense boundaries just below and above it. It panics if the objective is
+// not positive.
+func LatencyBucketsForSLO(objectiveSeconds float64) []float64 {
+	if objectiveSeconds <= 0 {
+		panic("LatencyBucketsForSLO needs a positive objective")
+	}
+	buckets := make([]float64, len(sloBucketFactors))
+	for i, f := range sloBucketFactors {
+		buckets[i] = f * objectiveSeconds
+	}
+	return buckets
+}
+
+// durationBuckets returns the configured duration buckets or the defaults,
+// merged with Config.AdditionalDurationBuckets
+func (cfg *Config) durationBuckets() []float64 {
+	buckets := DefaultDurationBuckets()
+	if len(cfg.DurationBuckets) > 0 {
+		buckets = cfg.DurationBuckets
+	}
+	if len(cfg.AdditionalDurationBuckets) == 0 {
+		return buckets
+	}
+	return mergeBuckets(buckets, cfg.AdditionalDurationBuckets)
+}
+
+// mergeBuckets returns the sorted, deduplicated union of two bucket sets.
+// +Inf is dropped since every histogram has it implicitly. It panics on
+// NaN, which has no place in a strictly increasing sequence.
+func mergeBuckets(a, b []float64) []float64 {
+	merged := make([]float64, 0, len(a)+len(b))
+	for _, bound := range slices.Concat(a, b) {
+		if math.IsNaN(bound) {
+			panic("duration buckets can't contain NaN")
+		}
+		if !math.IsInf(bound, 1) {
+			merged = append(merged, bound)
+		}
+	}
+	slices.Sort(merged)
+	return slices.Compact(merged)
+}
+
+// ParseBuckets parses a bucket specification from a configuration file:
+//
+//	exp:start:factor:count   exponential, e.g. "exp:0.001:2:15"
+//	lin:start:width:count    linear, e.g. "lin:0:0.1:20"
+//	0.1,0.5,1,5              an explicit list
+//
+// The resulting buckets must be finite and strictly increasing; the +Inf
+// bucket is implicit.
+func ParseBuckets(spec string) ([]float64, error) {
+	spec = strings.TrimSpace(spec)
+	kind, params, found := strings.Cut(spec, ":")
+	if !found {
+		return parseBucketList(spec)
+	}
+
+	stepName := map[string]string{"exp": "factor", "lin": "width"}[kind]
+	if stepName == "" {
+		return nil, fmt.Errorf("prommonitoring: bucket spec %q: unknown kind %q, want exp or lin", spec, kind)
+	}
+	fields := strings.Split(params, ":")
+	if len(fields) != 3 {
+		return nil, fmt.Errorf("prommonitoring: bucket spec %q: want %s:start:%s:count", spec, kind, stepName)
+	}
+	start, err1 := strconv.ParseFloat(fields[0], 64)
+	step, err2 := strconv.ParseFloat(fields[1], 64)
+	count, err3 := strconv.Atoi(fields[2])
+	if err := errors.Join(err1, err2, err3); err != nil {
+		return nil, fmt.Errorf("prommonitoring: bucket spec %q: %w", spec, err)
+	}
+	if !isFinite(start) || !isFinite(step) {
+		return nil, fmt.Errorf("prommonitoring: bucket spec %q: start and %s must be finite", spec, stepName)
+	}
+	if count < 1 {
+		return nil, fmt.Errorf("prommonitoring: bucket spec %q: count must be at least 1", spec)
+	}
+
+	if kind == "exp" {
+		if start <= 0 || step <= 1 {
+			return nil, fmt.Errorf("prommonitoring: bucket spec %q: exp needs a positive start and a factor above 1", spec)
+		}
+		return checkBuckets(spec, prometheus.ExponentialBuckets(start, step, count))
+	}
+	if step <= 0 {
+		return nil, fmt.Errorf("prommonitoring: bucket spec %q: lin needs a positive width", spec)
+	}
+	return checkBuckets(spec, prometheus.LinearBuckets(start, step, count))
+}
+
+// parseBucketList parses a comma-separated list of strictly increasing
+// bucket boundaries
+func parseBucketList(spec string) ([]float64, error) {
+	if spec == "" {
+		return nil, fmt.Errorf("prommonitoring: empty bucket spec")
+	}
+	fields := strings.Split(spec, ",")
+	buckets := make([]float64, len(fields))
+	for i, field := range fields {
+		bound, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if err != nil {
+			return nil, fmt.Errorf("prommonitoring: bucket spec %q: invalid boundary %q", spec, field)
+		}
+		buckets[i] = bound
+	}
+	return checkBuckets(spec, buckets)
+}
+
+// checkBuckets returns an error unless the buckets parsed from spec are
+// finite and strictly increasing, which also catches generated bounds
+// that overflowed or lost precision
+func checkBuckets(spec string, buckets []float64) ([]float64, error) {
+	for i, bound := range buckets {
+		if !isFinite(bound) {
+			return nil, fmt.Errorf("prommonitoring: bucket spec %q: boundary %v is not finite", spec, bound)
+		}
+		if i > 0 && bound <= buckets[i-1] {
+			return nil, fmt.Errorf("prommonitoring: bucket spec %q: boundaries must be strictly increasing", spec)
+		}
+	}
+	return buckets, nil
+}
+
+func isFinite(f float64) bool {
+	return !math.IsNaN(f) && !math.IsInf(f, 0)
+}
@@ -0,0 +1,115 @@
+package prommonitoring
+
+import (
+	"slices"
+	"testing"
+)
+
+// assertIncreasing fails unless the buckets are strictly increasing
+func assertIncreasing(t *testing.T, buckets []float64) {
+	t.Helper()
+	for i := 1; i < len(buckets); i++ {
+		if buckets[i] <= buckets[i-1] {
+			t.Fatalf("buckets %v are not strictly increasing at %d", buckets, i)
+		}
+	}
+}
+
+func TestLinearLatencyBuckets(t *testing.T) {
+	buckets := LinearLatencyBuckets(0.5, 0.25, 4)
+	if want := []float64{0.5, 0.75, 1, 1.25}; !slices.Equal(buckets, want) {
+		t.Errorf("LinearLatencyBuckets = %v, want %v", buckets, want)
+	}
+	assertIncreasing(t, buckets)
+
+	defer func() {
+		if recover() == nil {
+			t.Error("LinearLatencyBuckets accepted a zero width")
+		}
+	}()
+	LinearLatencyBuckets(1, 0, 3)
+}
+
+func TestPrometheusDefBucketsIsACopy(t *testing.T) {
+	buckets := PrometheusDefBuckets()
+	assertIncreasing(t, buckets)
+	buckets[0] = -1
+	if PrometheusDefBuckets()[0] == -1 {
+		t.Error("PrometheusDefBuckets returned the shared slice")
+	}
+}
+
+func TestLatencyBucketsForSLO(t *testing.T) {
+	for _, objective := range []float64{0.003, 0.1, 0.3, 2.5, 7} {
+		buckets := LatencyBucketsForSLO(objective)
+		assertIncreasing(t, buckets)
+
+		i := slices.Index(buckets, objective)
+		if i < 0 {
+			t.Errorf("objective %v is not a boundary of %v", objective, buckets)
+			continue
+		}
+		// The buckets next to the objective are its densest
+		below, above := buckets[i]-buckets[i-1], buckets[i+1]-buckets[i]
+		for j := 1; j < len(buckets); j++ {
+			if width := buckets[j] - buckets[j-1]; width < min(below, above)*0.999 {
+				t.Errorf("objective %v: bucket %d is narrower (%v) than those around the objective", objective, j, width)
+			}
+		}
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("LatencyBucketsForSLO accepted a zero objective")
+		}
+	}()
+	LatencyBucketsForSLO(0)
+}
+
+func TestParseBuckets(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    []float64
+		wantErr bool
+	}{
+		{spec: "exp:1:2:4", want: []float64{1, 2, 4, 8}},
+		{spec: " lin:0:0.5:3 ", want: []float64{0, 0.5, 1}},
+		{spec: "0.1, 0.5,1", want: []float64{0.1, 0.5, 1}},
+		{spec: "5", want: []float64{5}},
+
+		{spec: "", wantErr: true},
+		{spec: "log:1:2:3", wantErr: true},
+		{spec: "exp:1:2", wantErr: true},
+		{spec: "exp:1:2:0", wantErr: true},
+		{spec: "exp:0:2:3", wantErr: true},
+		{spec: "exp:1:1:3", wantErr: true},
+		{spec: "exp:NaN:2:3", wantErr: true},
+		{spec: "exp:1:Inf:3", wantErr: true},
+		{spec: "exp:1:10:400", wantErr: true}, // overflows into +Inf
+		{spec: "lin:0:0:3", wantErr: true},
+		{spec: "lin:Inf:1:3", wantErr: true},
+		{spec: "lin:1e300:NaN:3", wantErr: true},
+		{spec: "lin:1e20:1:3", wantErr: true}, // width lost to precision
+		{spec: "1,x", wantErr: true},
+		{spec: "1,NaN", wantErr: true},
+		{spec: "1,+Inf", wantErr: true},
+		{spec: "1,1", wantErr: true},
+		{spec: "2,1", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseBuckets(tt.spec)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseBuckets(%q) = %v, want an error", tt.spec, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseBuckets(%q): %v", tt.spec, err)
+			continue
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("ParseBuckets(%q) = %v, want %v", tt.spec, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package prommonitoring
+
+import (
+	"strconv"
+	"strings"
+)
+
+// cacheTTLBuckets covers max-age values from a second to a year
+var cacheTTLBuckets = []float64{1, 10, 60, 300, 900, 3600, 14400, 86400, 604800, 2592000, 31536000}
+
+// maxAge returns the max-age directive of a Cache-Control header value
+func maxAge(cacheControl string) (uint64, bool) {
+	for _, directive := range strings.Split(cacheControl, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(directive), "=")
+		if !ok || !strings.EqualFold(strings.TrimSpace(name), "max-age") {
+			continue
+		}
+		seconds, err := strconv.ParseUint(strings.Trim(strings.TrimSpace(value), `"`), 10, 63)
+		return seconds, err == nil
+	}
+	return 0, false
+}
+
+// observeCacheTTL records the max-age of the response, if it has one
+func (m *Metrics) observeCacheTTL(t *trackedRequest, info *requestInfo) {
+	// The header as sent, or as left by the handler if it wrote nothing
+	cacheControl := strings.Join(t.recorder.Header().Values("Cache-Control"), ",")
+	if mw := t.metricsWriter; mw != nil {
+		if sent := mw.cacheControl.Load(); sent != nil {
+			cacheControl = *sent
+		}
+	}
+	if seconds, ok := maxAge(cacheControl); ok {
+		m.ResponseCacheTTL.WithLabelValues(m.labelValues(m.routeLabels, info)...).Observe(float64(seconds))
+	}
+}
@@ -0,0 +1,107 @@
+package prommonitoring
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// DefaultClientClass is the client_class label of requests matching no
+// rule
+const DefaultClientClass = "other"
+
+// ClientClassRule assigns Class to requests whose User-Agent contains any
+// of Substrings (case-insensitively)
+type ClientClassRule struct {
+	Class      string
+	Substrings []string
+}
+
+// DefaultClientClassRules returns rules recognising common health probes,
+// crawlers and browsers
+func DefaultClientClassRules() []ClientClassRule {
+	return []ClientClassRule{
+		{Class: "probe", Substrings: []string{"kube-probe", "ELB-HealthChecker", "GoogleHC", "Pingdom", "UptimeRobot", "Prometheus", "blackbox"}},
+		{Class: "bot", Substrings: []string{"bot", "crawler", "spider", "slurp"}},
+		{Class: "browser", Substrings: []string{"Mozilla"}},
+	}
+}
+
+// clientClass classifies the request by its User-Agent. Rules are tried in
+// order, so the label values are bounded by the configured classes.
+func (m *Metrics) clientClass(r *http.Request) string {
+	ua := strings.ToLower(r.UserAgent())
+	if ua == "" {
+		return DefaultClientClass
+	}
+	for _, rule := range m.clientClassRules {
+		for _, substring := range rule.Substrings {
+			if strings.Contains(ua, substring) {
+				return rule.Class
+			}
+		}
+	}
+	return DefaultClientClass
+}
+
+// lowerClientClassRules lowercases the substrings once, at construction
+func lowerClientClassRules(rules []ClientClassRule) []ClientClassRule {
+	out := make([]ClientClassRule, len(rules))
+	for i, rule := range rules {
+		out[i].Class = rule.Class
+		out[i].Substrings = lowerStrings(rule.Substrings)
+	}
+	return out
+}
+
+func lowerStrings(ss []string) []string {
+	out := make([]string, len(ss))
+	for i, s := range ss {
+		out[i] = strings.ToLower(s)
+	}
+	return out
+}
+
+// DefaultBotPatterns returns User-Agent substrings of well-known crawlers
+func DefaultBotPatterns() []string {
+	return []string{
+		"Googlebot", "bingbot", "Slurp", "DuckDuckBot", "Baiduspider",
+		"YandexBot", "Applebot", "facebookexternalhit", "AhrefsBot", "SemrushBot",
+	}
+}
+
+// isBot reports, as a label value, whether the User-Agent contains one of
+// the configured crawler patterns
+func (m *Metrics) isBot(r *http.Request) string {
+	ua := strings.ToLower(r.UserAgent())
+	for _, pattern := range m.botPatterns {
+		if strings.Contains(ua, pattern) {
+			return "true"
+		}
+	}
+	return "false"
+}
+
+// CapClientClassifier guards a Config.ClientClassifier against unbounded
+// output: the first n distinct values pass through and any further one
+// is reported as CappedPathLabel
+func CapClientClassifier(classify func(*http.Request) string, n int) func(*http.Request) string {
+	limit := CapCardinality(n)
+	return func(r *http.Request) string {
+		return limit(classify(r))
+	}
+}
+
+// sniLabel returns the TLS server name of the request if it is one of
+// Config.SNIHosts, "other" if not, and an empty value, which Prometheus
+// treats as no label, for plaintext requests
+func (m *Metrics) sniLabel(r *http.Request) string {
+	if r.TLS == nil {
+		return ""
+	}
+	name := strings.ToLower(r.TLS.ServerName)
+	if slices.Contains(m.sniHosts, name) {
+		return name
+	}
+	return DefaultClientClass
+}
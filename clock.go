@@ -0,0 +1,17 @@
+package prommonitoring
+
+import "time"
+
+// now returns the current time from Config.Now, or from the wall clock
+func (m *Metrics) now() time.Time {
+	if m.cfg.Now != nil {
+		return m.cfg.Now()
+	}
+	return time.Now()
+}
+
+// since returns the time elapsed from start on the clock now. It is clamped
+// to zero, so a clock stepping backwards can't produce negative durations.
+func since(now func() time.Time, start time.Time) time.Duration {
+	return max(now().Sub(start), 0)
+}
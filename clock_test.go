@@ -0,0 +1,122 @@
+package prommonitoring
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+// fakeClock is a Config.Now that only moves when told to
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func TestInjectedClockDuration(t *testing.T) {
+	for name, step := range map[string]time.Duration{
+		"forward":  2 * time.Second,
+		"backward": -5 * time.Second,
+	} {
+		t.Run(name, func(t *testing.T) {
+			clock := newFakeClock()
+			cfg := DefaultConfig()
+			cfg.Now = clock.Now
+			m, reg := newTestMetrics(t, cfg)
+
+			handler := m.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				clock.Advance(step)
+			}))
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+			sum, count := histogramSum(t, reg, "app_http_request_duration_seconds")
+			if want := max(step, 0).Seconds(); count != 1 || sum != want {
+				t.Errorf("duration = %v over %d requests, want %v over 1", sum, count, want)
+			}
+		})
+	}
+}
+
+func TestInjectedClockBodyReadDuration(t *testing.T) {
+	clock := newFakeClock()
+	cfg := DefaultConfig()
+	cfg.Now = clock.Now
+	cfg.MeasureBodyReadTime = true
+	m, reg := newTestMetrics(t, cfg)
+
+	handler := m.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		buf := make([]byte, 1)
+		_, _ = r.Body.Read(buf)
+		clock.Advance(3 * time.Second)
+		_, _ = io.Copy(io.Discard, r.Body)
+	}))
+	body := io.NopCloser(iotest.OneByteReader(strings.NewReader("abc")))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/upload", body))
+
+	if sum, _ := histogramSum(t, reg, "app_http_request_body_read_seconds"); sum != 3 {
+		t.Errorf("body read duration = %v, want 3", sum)
+	}
+}
+
+func TestInjectedClockQueueDuration(t *testing.T) {
+	clock := newFakeClock()
+	cfg := DefaultConfig()
+	cfg.Now = clock.Now
+	cfg.MaxConcurrent = 1
+	cfg.MaxQueued = 1
+	m, reg := newTestMetrics(t, cfg)
+
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	handler := m.ConcurrencyLimitMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/first" {
+			close(entered)
+			<-release
+		}
+	}))
+
+	firstDone := make(chan struct{})
+	go func() {
+		defer close(firstDone)
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/first", nil))
+	}()
+	<-entered
+
+	secondDone := make(chan struct{})
+	go func() {
+		defer close(secondDone)
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/second", nil))
+	}()
+	// Let the second request queue, then free the slot 4s later
+	for m.limiter.queued.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	clock.Advance(4 * time.Second)
+	close(release)
+	<-firstDone
+	<-secondDone
+
+	if sum, count := histogramSum(t, reg, "app_http_request_queue_seconds"); count != 2 || sum != 4 {
+		t.Errorf("queue time = %v over %d requests, want 4 over 2", sum, count)
+	}
+}
@@ -0,0 +1,12 @@
+package prommonitoring
+
+import "sync/atomic"
+
+// processWarm is set once the process started handling its first request
+var processWarm atomic.Bool
+
+// isColdStart reports whether this is the first request the process
+// handles, which is true for exactly one caller
+func isColdStart() bool {
+	return !processWarm.Load() && processWarm.CompareAndSwap(false, true)
+}
@@ -0,0 +1,35 @@
+package prommonitoring
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// Describe implements prometheus.Collector, so the whole Metrics bundle,
+// including the enabled optional metrics, can be registered in one call:
+//
+//	reg.MustRegister(m)
+//
+// Don't also register the individual vectors on the same registry.
+func (m *Metrics) Describe(ch chan<- *prometheus.Desc) {
+	for _, c := range m.collectors() {
+		c.Describe(ch)
+	}
+}
+
+// Collect implements prometheus.Collector
+func (m *Metrics) Collect(ch chan<- prometheus.Metric) {
+	for _, c := range m.collectors() {
+		c.Collect(ch)
+	}
+}
+
+// Reset deletes every labelled series of the request metrics, including
+// the enabled optional ones, so counters start again from zero. It lets
+// tests reuse one Metrics across cases without registering it again;
+// in production it breaks rate() and increase() over the reset.
+// Unlabelled gauges and histograms keep their values.
+func (m *Metrics) Reset() {
+	for _, c := range m.collectors() {
+		if v, ok := c.(interface{ Reset() }); ok {
+			v.Reset()
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package prommonitoring
+
+import "context"
+
+// compressionRatioBuckets are the buckets of
+// http_response_compression_ratio
+var compressionRatioBuckets = []float64{1, 1.5, 2, 3, 4, 5, 7.5, 10, 20}
+
+// SetUncompressedSize reports the size of the response body before
+// compression, for the ratio recorded with Config.TrackCompressionRatio.
+// Handlers or compression middleware inside Middleware call it, since
+// Middleware only sees the compressed bytes. It does nothing outside
+// Middleware.
+func SetUncompressedSize(ctx context.Context, n int64) {
+	if state := requestStateFromContext(ctx); state != nil {
+		state.uncompressedSize.Store(n)
+	}
+}
+
+// observeCompressionRatio records the ratio of the reported uncompressed
+// size to the bytes written, for encoded responses only
+func (m *Metrics) observeCompressionRatio(t *trackedRequest, info *requestInfo, responseSize int64) {
+	state := requestStateFromContext(t.request.Context())
+	uncompressed := state.uncompressedSize.Load()
+	if uncompressed <= 0 || responseSize <= 0 {
+		return
+	}
+	if encoding := t.recorder.Header().Get("Content-Encoding"); encoding == "" || encoding == "identity" {
+		return
+	}
+	ratio := float64(uncompressed) / float64(responseSize)
+	m.CompressionRatio.WithLabelValues(m.labelValues(m.pathLabels, info)...).Observe(ratio)
+}
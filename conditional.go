@@ -0,0 +1,90 @@
+package prommonitoring
+
+import (
+	"bytes"
+	"hash/fnv"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// bufferedResponse captures a response so it can be inspected before it
+// is sent
+type bufferedResponse struct {
+	header     http.Header
+	statusCode int
+	body       bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header { return b.header }
+
+func (b *bufferedResponse) WriteHeader(statusCode int) {
+	if b.statusCode == 0 {
+		b.statusCode = statusCode
+	}
+}
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	if b.statusCode == 0 {
+		b.statusCode = http.StatusOK
+	}
+	return b.body.Write(p)
+}
+
+// noStoreMiddleware stops intermediaries from caching the exposition
+func noStoreMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+		next.ServeHTTP(w, r)
+	})
+}
+
+// conditionalMiddleware sets an ETag computed from a hash of the rendered
+// exposition and answers 304 Not Modified when it matches If-None-Match.
+// The exposition is still generated on every scrape; only the transfer is
+// saved. Since the hash covers the encoded body, gzip and plain responses
+// get distinct tags.
+func conditionalMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		buf := &bufferedResponse{header: http.Header{}}
+		next.ServeHTTP(buf, r)
+
+		for key, values := range buf.header {
+			w.Header()[key] = values
+		}
+		if buf.statusCode == 0 {
+			buf.statusCode = http.StatusOK
+		}
+		if buf.statusCode != http.StatusOK {
+			w.WriteHeader(buf.statusCode)
+			_, _ = w.Write(buf.body.Bytes())
+			return
+		}
+
+		h := fnv.New64a()
+		_, _ = h.Write(buf.body.Bytes())
+		etag := `"` + strconv.FormatUint(h.Sum64(), 16) + `"`
+		w.Header().Set("ETag", etag)
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		if etagMatches(r.Header.Get("If-None-Match"), etag) {
+			w.Header().Del("Content-Length")
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(buf.body.Bytes())
+	})
+}
+
+// etagMatches implements the weak comparison of an If-None-Match header
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
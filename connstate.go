@@ -0,0 +1,58 @@
+package prommonitoring
+
+import (
+	"net"
+	"net/http"
+	"sync"
+)
+
+// connTracker remembers the last state of every open connection, so the
+// gauge of that state can be decremented on the next transition
+type connTracker struct {
+	states sync.Map // net.Conn -> http.ConnState
+}
+
+// InstrumentServer tracks the connections of srv in the
+// http_connections{state} gauge and counts accepted connections in
+// http_connections_total, surfacing keep-alive behaviour and connection
+// leaks that request metrics miss. An existing srv.ConnState callback is
+// still called. It requires Config.TrackConnections.
+//
+// With Config.TrackTLSHandshakeErrors, it also counts the failed TLS
+// handshakes of srv in tls_handshake_errors_total{reason}. They are read
+// from the server error log, whose lines are still written to the
+// previous srv.ErrorLog or the standard logger.
+func (m *Metrics) InstrumentServer(srv *http.Server) {
+	if m.TLSHandshakeErrors != nil {
+		srv.ErrorLog = m.instrumentErrorLog(srv.ErrorLog)
+	}
+	if m.Connections == nil {
+		return
+	}
+
+	previous := srv.ConnState
+	srv.ConnState = func(conn net.Conn, state http.ConnState) {
+		m.trackConnState(conn, state)
+		if previous != nil {
+			previous(conn, state)
+		}
+	}
+}
+
+func (m *Metrics) trackConnState(conn net.Conn, state http.ConnState) {
+	if state == http.StateNew {
+		m.ConnectionsTotal.Inc()
+	}
+
+	if last, ok := m.conns.states.Load(conn); ok {
+		m.Connections.WithLabelValues(last.(http.ConnState).String()).Dec()
+	}
+
+	switch state {
+	case http.StateClosed, http.StateHijacked:
+		m.conns.states.Delete(conn)
+	default:
+		m.conns.states.Store(conn, state)
+		m.Connections.WithLabelValues(state.String()).Inc()
+	}
+}
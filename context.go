@@ -0,0 +1,95 @@
+package prommonitoring
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// requestState is the per-request state Middleware stores in the request
+// context, shared with handlers through the context helpers below
+type requestState struct {
+	start       time.Time
+	now         func() time.Time
+	service     atomic.Pointer[string]
+	operationID atomic.Pointer[string]
+	recorder    *RequestRecorder
+	observers   *requestObservers
+
+	// uncompressedSize is set with SetUncompressedSize, 0 until then
+	uncompressedSize atomic.Int64
+
+	// backend latency reported with RecordBackendLatency
+	backendLatency  atomic.Int64
+	backendReported atomic.Bool
+}
+
+type requestStateKey struct{}
+
+func withRequestState(ctx context.Context, state *requestState) context.Context {
+	return context.WithValue(ctx, requestStateKey{}, state)
+}
+
+func requestStateFromContext(ctx context.Context) *requestState {
+	state, _ := ctx.Value(requestStateKey{}).(*requestState)
+	return state
+}
+
+// ElapsedFromContext returns the time elapsed since Middleware started
+// measuring the request, so logging layers can report the same latency
+// without their own bookkeeping. It returns false outside Middleware.
+func ElapsedFromContext(ctx context.Context) (time.Duration, bool) {
+	state := requestStateFromContext(ctx)
+	if state == nil {
+		return 0, false
+	}
+	return since(state.now, state.start), true
+}
+
+type serviceKey struct{}
+
+// WithService records the upstream service a gateway routes the request
+// to, reported in the service label when Config.ServiceLabel is set.
+// Inside Middleware the request is updated in place, so routing layers
+// may call it on a derived context.
+func WithService(ctx context.Context, name string) context.Context {
+	if state := requestStateFromContext(ctx); state != nil {
+		state.service.Store(&name)
+	}
+	return context.WithValue(ctx, serviceKey{}, name)
+}
+
+// serviceFromContext returns the service recorded with WithService
+func serviceFromContext(ctx context.Context) string {
+	if state := requestStateFromContext(ctx); state != nil {
+		if name := state.service.Load(); name != nil {
+			return *name
+		}
+	}
+	name, _ := ctx.Value(serviceKey{}).(string)
+	return name
+}
+
+type operationIDKey struct{}
+
+// WithOperationID records the stable ID of the operation handling the
+// request, e.g. its OpenAPI operationId, used by PathLabelOperation and
+// Config.OperationLabel. Like WithService, it updates the request in
+// place inside Middleware, so handlers may call it on their own context.
+func WithOperationID(ctx context.Context, id string) context.Context {
+	if state := requestStateFromContext(ctx); state != nil {
+		state.operationID.Store(&id)
+	}
+	return context.WithValue(ctx, operationIDKey{}, id)
+}
+
+// operationIDFromContext returns the ID recorded with WithOperationID
+func operationIDFromContext(ctx context.Context) string {
+	if state := requestStateFromContext(ctx); state != nil {
+		if id := state.operationID.Load(); id != nil {
+			return *id
+		}
+	}
+	id, _ := ctx.Value(operationIDKey{}).(string)
+	return id
+}
@@ -0,0 +1,92 @@
+package prommonitoring
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+)
+
+// createdLinesHandler answers OpenMetrics scrapes itself, encoding the
+// _created line of every counter, histogram and summary, which promhttp
+// leaves out. Other formats are served by next.
+//
+// A series is created when it is first observed, not when the Metrics are
+// constructed or registered: an unlabelled counter reports its
+// construction time, each label set of a vector the time of its first
+// observation.
+func createdLinesHandler(gatherer prometheus.Gatherer, next http.Handler, disableCompression bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		format := expfmt.NegotiateIncludingOpenMetrics(r.Header)
+		if format.FormatType() != expfmt.TypeOpenMetrics {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		mfs, err := gatherer.Gather()
+		if err != nil {
+			http.Error(w, "An error has occurred while gathering metrics:\n\n"+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", string(format))
+		var out io.Writer = w
+		if !disableCompression && acceptsGzip(r.Header) {
+			w.Header().Set("Content-Encoding", "gzip")
+			gz := gzip.NewWriter(w)
+			defer gz.Close()
+			out = gz
+		}
+
+		enc := expfmt.NewEncoder(out, format, expfmt.WithCreatedLines())
+		for _, mf := range mfs {
+			if err := enc.Encode(mf); err != nil {
+				return
+			}
+		}
+		if closer, ok := enc.(expfmt.Closer); ok {
+			_ = closer.Close()
+		}
+	})
+}
+
+// acceptsGzip reports whether the Accept-Encoding header allows gzip,
+// either by name or through "*", honoring q=0 as a refusal
+func acceptsGzip(h http.Header) bool {
+	wildcard := false
+	for _, value := range h.Values("Accept-Encoding") {
+		for _, item := range strings.Split(value, ",") {
+			coding, params, _ := strings.Cut(item, ";")
+			accepted := qValue(params) > 0
+			switch strings.ToLower(strings.TrimSpace(coding)) {
+			case "gzip", "x-gzip":
+				// An explicit entry overrides the wildcard
+				return accepted
+			case "*":
+				wildcard = accepted
+			}
+		}
+	}
+	return wildcard
+}
+
+// qValue returns the weight in the parameters of an Accept-Encoding
+// entry, 1 if there is none and 0 if it is malformed
+func qValue(params string) float64 {
+	for _, param := range strings.Split(params, ";") {
+		name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+		if !strings.EqualFold(name, "q") {
+			continue
+		}
+		q, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || q < 0 || q > 1 {
+			return 0
+		}
+		return q
+	}
+	return 1
+}
@@ -0,0 +1,104 @@
+package prommonitoring
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const openMetricsAccept = "application/openmetrics-text;version=1.0.0,text/plain;version=0.0.4;q=0.5"
+
+func TestOpenMetricsCreatedLines(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.OpenMetricsCreated = true
+	m, err := NewMetricsWithRegisterer(cfg, cfg.Registry)
+	if err != nil {
+		t.Fatalf("NewMetricsWithRegisterer: %v", err)
+	}
+
+	before := time.Now()
+	serveOne(m)
+	after := time.Now()
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Accept", openMetricsAccept)
+	rec := httptest.NewRecorder()
+	MetricsHandler(cfg).ServeHTTP(rec, req)
+
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/openmetrics-text") {
+		t.Fatalf("Content-Type = %q, want OpenMetrics", ct)
+	}
+
+	var created string
+	for _, line := range strings.Split(rec.Body.String(), "\n") {
+		if strings.HasPrefix(line, "app_http_requests_created{") {
+			created = line[strings.LastIndexByte(line, ' ')+1:]
+		}
+	}
+	if created == "" {
+		t.Fatalf("no _created line for app_http_requests in:\n%s", rec.Body.String())
+	}
+	seconds, err := strconv.ParseFloat(created, 64)
+	if err != nil {
+		t.Fatalf("created timestamp %q: %v", created, err)
+	}
+	// The series was created by its first observation
+	ts := time.Unix(0, int64(seconds*1e9))
+	if ts.Before(before.Truncate(time.Millisecond)) || ts.After(after.Add(time.Millisecond)) {
+		t.Errorf("created = %v, want between %v and %v", ts, before, after)
+	}
+}
+
+func TestOpenMetricsCreatedCompression(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	cfg := &Config{Registry: reg, OpenMetricsCreated: true}
+	c := prometheus.NewCounter(prometheus.CounterOpts{Name: "jobs_total", Help: "Jobs"})
+	reg.MustRegister(c)
+	handler := MetricsHandler(cfg)
+
+	for acceptEncoding, wantGzip := range map[string]bool{
+		"":                false,
+		"gzip":            true,
+		"br, gzip;q=0.5":  true,
+		"gzip;q=0":        false,
+		"gzip; q=0.000":   false,
+		"*":               true,
+		"*, gzip;q=0":     false,
+		"identity, *;q=0": false,
+		"deflate":         false,
+		"GZIP;Q=1":        true,
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		req.Header.Set("Accept", openMetricsAccept)
+		if acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		gzipped := rec.Header().Get("Content-Encoding") == "gzip"
+		if gzipped != wantGzip {
+			t.Errorf("Accept-Encoding %q: gzipped = %v, want %v", acceptEncoding, gzipped, wantGzip)
+			continue
+		}
+		var body io.Reader = rec.Body
+		if gzipped {
+			gz, err := gzip.NewReader(rec.Body)
+			if err != nil {
+				t.Fatalf("Accept-Encoding %q: %v", acceptEncoding, err)
+			}
+			body = gz
+		}
+		text, _ := io.ReadAll(body)
+		if !strings.Contains(string(text), "jobs_created ") {
+			t.Errorf("Accept-Encoding %q: no _created line in:\n%s", acceptEncoding, text)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package prommonitoring
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// MetricDescriptor describes a metric exposed by Metrics
+type MetricDescriptor struct {
+	// Name is the fully-qualified name, including the namespace
+	Name string `json:"name"`
+	// Type is "counter", "gauge", "histogram" or "summary"
+	Type string `json:"type"`
+	Help string `json:"help"`
+	// Labels are the variable label names, in order
+	Labels []string `json:"labels"`
+}
+
+// Descriptors lists the metrics of m, including the enabled optional
+// ones, as constructed from its configuration. It is meant for generating
+// metrics catalogs and checking naming conventions.
+func (m *Metrics) Descriptors() []MetricDescriptor {
+	var out []MetricDescriptor
+	for _, c := range m.collectors() {
+		metricType := collectorType(c)
+		for _, desc := range describe(c) {
+			d := parseDesc(desc)
+			d.Type = metricType
+			out = append(out, d)
+		}
+	}
+	return out
+}
+
+// collectorType returns the metric type of the collectors Metrics uses.
+// Unlabelled metrics are typed by what they write: the interfaces overlap,
+// a Gauge being a Counter and a Summary a Histogram.
+func collectorType(c prometheus.Collector) string {
+	switch c := c.(type) {
+	case *prometheus.CounterVec:
+		return "counter"
+	case *prometheus.HistogramVec:
+		return "histogram"
+	case *prometheus.SummaryVec:
+		return "summary"
+	case prometheus.Metric:
+		var out dto.Metric
+		if err := c.Write(&out); err != nil {
+			break
+		}
+		switch {
+		case out.Counter != nil:
+			return "counter"
+		case out.Histogram != nil:
+			return "histogram"
+		case out.Summary != nil:
+			return "summary"
+		}
+	}
+	return "gauge"
+}
+
+// describe returns the descriptors of a collector
+func describe(c prometheus.Collector) []*prometheus.Desc {
+	ch := make(chan *prometheus.Desc)
+	go func() {
+		c.Describe(ch)
+		close(ch)
+	}()
+
+	var descs []*prometheus.Desc
+	for desc := range ch {
+		descs = append(descs, desc)
+	}
+	return descs
+}
+
+// parseDesc extracts the name, help and variable labels from the string
+// form of a descriptor, the only form in which prometheus exposes them
+func parseDesc(desc *prometheus.Desc) MetricDescriptor {
+	s := desc.String()
+
+	d := MetricDescriptor{Labels: []string{}}
+	_, rest, _ := strings.Cut(s, "fqName: ")
+	if quoted, err := strconv.QuotedPrefix(rest); err == nil {
+		d.Name, _ = strconv.Unquote(quoted)
+		rest = rest[len(quoted):]
+	}
+	_, rest, _ = strings.Cut(rest, "help: ")
+	if quoted, err := strconv.QuotedPrefix(rest); err == nil {
+		d.Help, _ = strconv.Unquote(quoted)
+	}
+
+	// The variable labels come last, after const label values that may
+	// contain anything
+	if i := strings.LastIndex(s, "variableLabels: {"); i >= 0 {
+		labels := strings.TrimSuffix(s[i+len("variableLabels: {"):], "}}")
+		if labels != "" {
+			d.Labels = strings.Split(labels, ",")
+		}
+	}
+	return d
+}
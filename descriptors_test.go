@@ -0,0 +1,30 @@
+package prommonitoring
+
+import "testing"
+
+func TestDescriptorsTypes(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.MaxConcurrent = 4
+	cfg.EnableRequestID = true
+	cfg.DurationSummary = true
+	m := NewMetricsWithConfig(cfg)
+
+	types := make(map[string]string)
+	for _, d := range m.Descriptors() {
+		types[d.Name] = d.Type
+	}
+
+	for name, want := range map[string]string{
+		"app_http_requests_total":                   "counter", // CounterVec
+		"app_http_request_id_generated_total":       "counter", // Counter
+		"app_http_requests_in_flight":               "gauge",   // GaugeVec
+		"app_http_saturation_ratio":                 "gauge",   // Gauge
+		"app_http_request_duration_seconds":         "histogram",
+		"app_http_request_queue_seconds":            "histogram",
+		"app_http_request_duration_summary_seconds": "summary",
+	} {
+		if got := types[name]; got != want {
+			t.Errorf("type of %s = %q, want %q", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package prommonitoring
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	drainPollMin = time.Millisecond
+	drainPollMax = 100 * time.Millisecond
+)
+
+// WaitForDrain blocks until no request is inside the middleware or ctx is
+// done, in which case it returns the context's error. Call it after
+// http.Server.Shutdown or once the listener stopped accepting requests;
+// requests passed through while disabled with SetEnabled aren't counted.
+func (m *Metrics) WaitForDrain(ctx context.Context) error {
+	wait := drainPollMin
+	for m.inFlight.Load() > 0 {
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+		wait = min(wait*2, drainPollMax)
+	}
+	return nil
+}
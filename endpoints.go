@@ -0,0 +1,83 @@
+package prommonitoring
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// metricsEndpoints returns the handlers mounted under cfg.MetricsPath: the
+// exposition itself and the sub-endpoints enabled in the configuration
+func metricsEndpoints(cfg *Config, m *Metrics) map[string]http.Handler {
+	health, describe, reset := subEndpointPaths(cfg)
+	endpoints := map[string]http.Handler{cfg.MetricsPath: MetricsHandler(cfg)}
+	if cfg.MetricsHealthEndpoint {
+		endpoints[health] = metricsHealthHandler(m)
+	}
+	if cfg.MetricsDescribeEndpoint {
+		endpoints[describe] = describeHandler(m)
+	}
+	if cfg.MetricsResetEndpoint {
+		endpoints[reset] = resetHandler(m)
+	}
+	return endpoints
+}
+
+// metricsEndpointPaths returns the paths of the handlers of
+// metricsEndpoints
+func metricsEndpointPaths(cfg *Config) []string {
+	health, describe, reset := subEndpointPaths(cfg)
+	paths := []string{cfg.MetricsPath}
+	if cfg.MetricsHealthEndpoint {
+		paths = append(paths, health)
+	}
+	if cfg.MetricsDescribeEndpoint {
+		paths = append(paths, describe)
+	}
+	if cfg.MetricsResetEndpoint {
+		paths = append(paths, reset)
+	}
+	return paths
+}
+
+// subEndpointPaths returns the paths of the sub-endpoints
+func subEndpointPaths(cfg *Config) (health, describe, reset string) {
+	prefix := strings.TrimSuffix(cfg.MetricsPath, "/")
+	return prefix + "/health", prefix + "/describe", prefix + "/reset"
+}
+
+// metricsHealthHandler answers 200 when the metrics were registered and
+// 503 otherwise
+func metricsHealthHandler(m *Metrics) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if m == nil || initError() != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte("metrics not registered\n"))
+			return
+		}
+		_, _ = w.Write([]byte("ok\n"))
+	})
+}
+
+// describeHandler serves the metric descriptors as JSON
+func describeHandler(m *Metrics) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(m.Descriptors())
+	})
+}
+
+// resetHandler resets the metrics on POST
+func resetHandler(m *Metrics) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		m.Reset()
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = w.Write([]byte("metrics reset\n"))
+	})
+}
@@ -0,0 +1,56 @@
+package prommonitoring
+
+import "net/http"
+
+// NotFoundHandling controls how 404 responses are counted in TotalErrors
+type NotFoundHandling int
+
+const (
+	// NotFoundAsError counts 404s as "client_error", like any other 4xx
+	NotFoundAsError NotFoundHandling = iota
+	// NotFoundSeparate counts 404s under their own "not_found" error type
+	NotFoundSeparate
+	// NotFoundIgnore doesn't count 404s as errors at all
+	NotFoundIgnore
+)
+
+// errorType classifies a response status for the error_type label, using
+// Config.ErrorTypeByStatus first and the 404 handling and client/server
+// split otherwise. It returns false for statuses that aren't counted as
+// errors.
+func (m *Metrics) errorType(statusCode int) (string, bool) {
+	if statusCode < 400 {
+		return "", false
+	}
+
+	if errorType, ok := m.cfg.ErrorTypeByStatus[statusCode]; ok {
+		return errorType, true
+	}
+
+	if statusCode == http.StatusNotFound {
+		switch m.cfg.NotFoundHandling {
+		case NotFoundSeparate:
+			return "not_found", true
+		case NotFoundIgnore:
+			return "", false
+		}
+	}
+
+	if statusCode >= 500 {
+		return "server_error", true
+	}
+	return "client_error", true
+}
+
+// DefaultSuccessStatus counts 2xx and 3xx responses as successes
+func DefaultSuccessStatus(statusCode int) bool {
+	return statusCode >= 200 && statusCode < 400
+}
+
+// isSuccess classifies a response status for the success counter
+func (m *Metrics) isSuccess(statusCode int) bool {
+	if m.cfg.SuccessStatus != nil {
+		return m.cfg.SuccessStatus(statusCode)
+	}
+	return DefaultSuccessStatus(statusCode)
+}
@@ -0,0 +1,27 @@
+package prommonitoring
+
+import (
+	"mime"
+	"strings"
+)
+
+// responseFormat maps the Content-Type of a response to the format label:
+// "json", "xml", "protobuf" or "other", which includes responses without
+// a Content-Type
+func responseFormat(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "other"
+	}
+	_, subtype, _ := strings.Cut(mediaType, "/")
+	switch {
+	case subtype == "json" || strings.HasSuffix(subtype, "+json"):
+		return "json"
+	case subtype == "xml" || strings.HasSuffix(subtype, "+xml"):
+		return "xml"
+	case subtype == "protobuf" || subtype == "x-protobuf" || subtype == "vnd.google.protobuf" ||
+		subtype == "grpc" || strings.HasSuffix(subtype, "+proto"):
+		return "protobuf"
+	}
+	return "other"
+}
@@ -0,0 +1,103 @@
+package prommonitoring
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// newTestMetrics creates metrics registered on a fresh registry
+func newTestMetrics(t *testing.T, cfg *Config) (*Metrics, *prometheus.Registry) {
+	t.Helper()
+	reg := prometheus.NewRegistry()
+	m, err := NewMetricsWithRegisterer(cfg, reg)
+	if err != nil {
+		t.Fatalf("NewMetricsWithRegisterer: %v", err)
+	}
+	return m, reg
+}
+
+// findMetric returns the metric of the named family whose labels include
+// the given ones, or nil
+func findMetric(t *testing.T, g prometheus.Gatherer, name string, labels map[string]string) *dto.Metric {
+	t.Helper()
+	families, err := g.Gather()
+	if err != nil {
+		t.Fatalf("Gather: %v", err)
+	}
+	for _, mf := range families {
+		if mf.GetName() != name {
+			continue
+		}
+	metrics:
+		for _, m := range mf.GetMetric() {
+			for k, v := range labels {
+				found := false
+				for _, lp := range m.GetLabel() {
+					if lp.GetName() == k && lp.GetValue() == v {
+						found = true
+					}
+				}
+				if !found {
+					continue metrics
+				}
+			}
+			return m
+		}
+	}
+	return nil
+}
+
+// counterValue returns the value of a counter, or 0 if it doesn't exist
+func counterValue(t *testing.T, g prometheus.Gatherer, name string, labels map[string]string) float64 {
+	t.Helper()
+	if m := findMetric(t, g, name, labels); m != nil {
+		return m.GetCounter().GetValue()
+	}
+	return 0
+}
+
+// familyNames returns the names of the gathered metric families
+func familyNames(t *testing.T, g prometheus.Gatherer) []string {
+	t.Helper()
+	families, err := g.Gather()
+	if err != nil {
+		t.Fatalf("Gather: %v", err)
+	}
+	names := make([]string, 0, len(families))
+	for _, mf := range families {
+		names = append(names, mf.GetName())
+	}
+	return names
+}
+
+// histogramSum returns the sample sum and count of a histogram
+func histogramSum(t *testing.T, g prometheus.Gatherer, name string) (float64, uint64) {
+	t.Helper()
+	metric := findMetric(t, g, name, nil)
+	if metric == nil {
+		t.Fatalf("%s not found", name)
+	}
+	return metric.GetHistogram().GetSampleSum(), metric.GetHistogram().GetSampleCount()
+}
+
+// serveOne records a request through the middleware of m
+func serveOne(m *Metrics) {
+	m.Middleware(http.NotFoundHandler()).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/x", nil))
+}
+
+// resetGlobalMetrics clears the instance of InitMetrics for the test
+func resetGlobalMetrics(t *testing.T) {
+	t.Helper()
+	reset := func() {
+		metrics.Store(nil)
+		metricsErr = nil
+		metricsOnce = sync.Once{}
+	}
+	reset()
+	t.Cleanup(reset)
+}
@@ -0,0 +1,66 @@
+package prommonitoring
+
+import (
+	"strings"
+	"sync"
+)
+
+// routeConcurrency counts the in-flight requests of every route, to feed
+// the high-water marks of Metrics.RequestsInFlightMax. Routes are removed
+// once they have no request in flight, so the map only holds routes with
+// requests currently being served.
+type routeConcurrency struct {
+	mu     sync.Mutex
+	routes map[string]*routeCount
+}
+
+type routeCount struct {
+	labelValues []string
+	n           int64
+}
+
+// add adjusts the in-flight count of a route and returns the new count.
+// labelValues are only needed when the route may not be tracked yet.
+func (c *routeConcurrency) add(key string, labelValues []string, delta int64) int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.routes == nil {
+		c.routes = make(map[string]*routeCount)
+	}
+	route, ok := c.routes[key]
+	if !ok {
+		route = &routeCount{labelValues: labelValues}
+		c.routes[key] = route
+	}
+	route.n += delta
+	if route.n <= 0 {
+		delete(c.routes, key)
+		return 0
+	}
+	return route.n
+}
+
+// each calls observe with the in-flight count of every busy route
+func (c *routeConcurrency) each(observe func(value float64, labelValues ...string)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, route := range c.routes {
+		observe(float64(route.n), route.labelValues...)
+	}
+}
+
+// startRouteInFlight counts the request in its route and raises the
+// route's high-water mark. It returns the route key to pass to
+// finishRouteInFlight once the request finished.
+func (m *Metrics) startRouteInFlight(info *requestInfo) string {
+	info.path = m.pathLabel(info.request)
+	labels := m.labelValues(m.routeLabels, info)
+	key := strings.Join(labels, "\xff")
+	m.RequestsInFlightMax.Observe(float64(m.routeInFlight.add(key, labels, 1)), labels...)
+	return key
+}
+
+// finishRouteInFlight removes a finished request from its route
+func (m *Metrics) finishRouteInFlight(key string) {
+	m.routeInFlight.add(key, nil, -1)
+}
@@ -0,0 +1,87 @@
+package prommonitoring
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestInFlightMaxTracksPeakAndForgetsIdleRoutes(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.TrackInFlightMax = true
+	m, reg := newTestMetrics(t, cfg)
+
+	// Two requests on /busy overlap, the others run one at a time
+	var arrived sync.WaitGroup
+	arrived.Add(2)
+	release := make(chan struct{})
+	handler := m.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/busy" {
+			arrived.Done()
+			<-release
+		}
+	}))
+
+	var done sync.WaitGroup
+	for range 2 {
+		done.Add(1)
+		go func() {
+			defer done.Done()
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/busy", nil))
+		}()
+	}
+	arrived.Wait()
+	for i := range 100 {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, fmt.Sprintf("/unmatched/%d", i), nil))
+	}
+	close(release)
+	done.Wait()
+
+	metric := findMetric(t, reg, "app_http_requests_in_flight_max", map[string]string{"path": "/busy"})
+	if metric == nil || metric.GetGauge().GetValue() != 2 {
+		t.Errorf("high-water mark of /busy = %v, want 2", metric)
+	}
+
+	m.routeInFlight.mu.Lock()
+	defer m.routeInFlight.mu.Unlock()
+	if n := len(m.routeInFlight.routes); n != 0 {
+		t.Errorf("%d routes still tracked after all requests finished", n)
+	}
+}
+
+func TestInFlightMaxKeepsLongRequestsAcrossScrapes(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.TrackInFlightMax = true
+	m, reg := newTestMetrics(t, cfg)
+
+	arrived := make(chan struct{})
+	release := make(chan struct{})
+	handler := m.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(arrived)
+		<-release
+	}))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/long", nil))
+	}()
+	<-arrived
+
+	for scrape := 1; scrape <= 2; scrape++ {
+		metric := findMetric(t, reg, "app_http_requests_in_flight_max", map[string]string{"path": "/long"})
+		if metric == nil || metric.GetGauge().GetValue() != 1 {
+			t.Errorf("scrape %d: high-water mark of /long = %v, want 1", scrape, metric)
+		}
+	}
+
+	close(release)
+	<-done
+	// The request finished after the last reset, so one more scrape
+	// reports it and the next one has forgotten the route
+	findMetric(t, reg, "app_http_requests_in_flight_max", nil)
+	if metric := findMetric(t, reg, "app_http_requests_in_flight_max", map[string]string{"path": "/long"}); metric != nil {
+		t.Errorf("idle route still reported: %v", metric)
+	}
+}
@@ -0,0 +1,222 @@
+package prommonitoring
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Profile presets the label configuration of the HTTP metrics
+type Profile string
+
+const (
+	// ProfileDetailed labels requests by method, path and status
+	ProfileDetailed Profile = "detailed"
+	// ProfileAggregate labels requests by method and status only, keeping
+	// the number of series independent of the URL space
+	ProfileAggregate Profile = "aggregate"
+)
+
+// DefaultRouterLabel is the router label of requests instrumented with
+// Middleware rather than MiddlewareForGroup
+const DefaultRouterLabel = "default"
+
+// IsIdempotentMethod reports whether requests with the given method are
+// idempotent as defined by RFC 9110, i.e. safe to retry
+func IsIdempotentMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace,
+		http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// requestInfo holds the label values derived from a single request
+type requestInfo struct {
+	request     *http.Request
+	method      string
+	path        string
+	status      string
+	statusClass string
+	errorType   string
+	router      string
+	coldStart   bool
+	contentType string
+}
+
+// labelValue returns the value of the named label for the request
+func (m *Metrics) labelValue(name string, info *requestInfo) string {
+	switch name {
+	case "method":
+		return info.method
+	case "path":
+		return info.path
+	case "status", "status_code":
+		return info.status
+	case "status_class":
+		return info.statusClass
+	case "error_type":
+		return info.errorType
+	case "api_version":
+		return m.apiVersion(info.request)
+	case "client_class":
+		return m.clientClass(info.request)
+	case "is_bot":
+		return m.isBot(info.request)
+	case "service":
+		return serviceFromContext(info.request.Context())
+	case "client_origin":
+		return m.cfg.ClientClassifier(info.request)
+	case "router":
+		return info.router
+	case "sni":
+		return m.sniLabel(info.request)
+	case "idempotent":
+		return strconv.FormatBool(IsIdempotentMethod(info.request.Method))
+	case "cold_start":
+		return strconv.FormatBool(info.coldStart)
+	case "format":
+		return responseFormat(info.contentType)
+	case "operation_id":
+		if id := operationIDFromContext(info.request.Context()); id != "" {
+			return m.sanitizeLabel(id)
+		}
+		return m.unknownPathLabel()
+	}
+	return ""
+}
+
+// labelValues resolves the values for the given label names, in order
+func (m *Metrics) labelValues(names []string, info *requestInfo) []string {
+	values := make([]string, len(names))
+	for i, name := range names {
+		values[i] = m.labelValue(name, info)
+	}
+	return values
+}
+
+// pathLabelDisabled reports whether the path label is dropped, either
+// explicitly or through the aggregate profile
+func (cfg *Config) pathLabelDisabled() bool {
+	return cfg.DisablePathLabel || cfg.Profile == ProfileAggregate
+}
+
+// labelEnabled reports whether the named label is in use. The core labels
+// are on by default while the optional ones are enabled by their Config
+// fields.
+func (cfg *Config) labelEnabled(name string) bool {
+	switch name {
+	case "path":
+		return !cfg.pathLabelDisabled()
+	case "api_version":
+		return cfg.APIVersionPattern != nil
+	case "client_class":
+		return cfg.ClientClassRules != nil
+	case "is_bot":
+		return cfg.BotPatterns != nil
+	case "service":
+		return cfg.ServiceLabel
+	case "client_origin":
+		return cfg.ClientClassifier != nil
+	case "operation_id":
+		return cfg.OperationLabel
+	case "router":
+		return cfg.RouterLabel
+	case "sni":
+		return cfg.SNIHosts != nil
+	case "idempotent":
+		return cfg.IdempotentLabel
+	case "cold_start":
+		return cfg.ColdStartLabel
+	case "format":
+		return cfg.FormatLabel
+	}
+	return true
+}
+
+// labelNames filters out the labels disabled in the configuration
+func (cfg *Config) labelNames(names ...string) []string {
+	out := make([]string, 0, len(names))
+	for _, name := range names {
+		if cfg.labelEnabled(name) {
+			out = append(out, name)
+		}
+	}
+	return out
+}
+
+// labelReplacement is substituted for invalid UTF-8 sequences and control
+// characters in label values
+const labelReplacement = "_"
+
+// sanitizeLabel makes a request-derived label value safe for exposition
+// when Config.SanitizeLabels is set
+func (m *Metrics) sanitizeLabel(s string) string {
+	if !m.cfg.SanitizeLabels {
+		return s
+	}
+	return sanitizeLabelValue(s)
+}
+
+// sanitizeLabelValue replaces invalid UTF-8 sequences and control
+// characters. Valid values are returned as is, without allocating.
+func sanitizeLabelValue(s string) string {
+	clean := true
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c < 0x20 || c == 0x7f || c >= 0x80 {
+			clean = false
+			break
+		}
+	}
+	if clean {
+		return s
+	}
+	if utf8.ValidString(s) && !strings.ContainsFunc(s, unicode.IsControl) {
+		return s
+	}
+
+	var b strings.Builder
+	b.Grow(len(s))
+	for i, r := range s {
+		if (r == utf8.RuneError && isInvalidRune(s[i:])) || unicode.IsControl(r) {
+			b.WriteString(labelReplacement)
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// isInvalidRune reports whether s starts with an invalid UTF-8 sequence,
+// as opposed to an encoded U+FFFD
+func isInvalidRune(s string) bool {
+	_, size := utf8.DecodeRuneInString(s)
+	return size <= 1
+}
+
+// DeploymentLabels returns the common {"deployment": name} label set for
+// Config.DeploymentLabel, e.g. DeploymentLabels("canary")
+func DeploymentLabels(name string) map[string]string {
+	return map[string]string{"deployment": name}
+}
+
+// constLabels merges Config.ConstLabels and Config.DeploymentLabel into
+// the constant labels applied to every metric
+func (cfg *Config) constLabels() prometheus.Labels {
+	if len(cfg.ConstLabels) == 0 && len(cfg.DeploymentLabel) == 0 {
+		return nil
+	}
+	labels := make(prometheus.Labels, len(cfg.ConstLabels)+len(cfg.DeploymentLabel))
+	for name, value := range cfg.ConstLabels {
+		labels[name] = value
+	}
+	for name, value := range cfg.DeploymentLabel {
+		labels[name] = value
+	}
+	return labels
+}
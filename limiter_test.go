@@ -0,0 +1,58 @@
+package prommonitoring
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSaturationExcludesQueuedRequests(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.MaxConcurrent = 1
+	cfg.MaxQueued = 3
+	cfg.QueueTimeout = time.Minute
+	m, reg := newTestMetrics(t, cfg)
+
+	saturation := func() float64 {
+		t.Helper()
+		metric := findMetric(t, reg, "app_http_saturation_ratio", nil)
+		if metric == nil {
+			t.Fatal("app_http_saturation_ratio not found")
+		}
+		return metric.GetGauge().GetValue()
+	}
+
+	release := make(chan struct{})
+	handler := m.Middleware(m.ConcurrencyLimitMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	})))
+
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/x", nil))
+		}()
+	}
+
+	// One request holds the slot, the three others wait for it
+	deadline := time.Now().Add(5 * time.Second)
+	for m.limiter.queued.Load() < 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d requests queued, want 3", m.limiter.queued.Load())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if got := saturation(); got != 1 {
+		t.Errorf("saturation with 3 queued requests = %v, want 1", got)
+	}
+
+	close(release)
+	wg.Wait()
+	if got := saturation(); got != 0 {
+		t.Errorf("saturation once idle = %v, want 0", got)
+	}
+}
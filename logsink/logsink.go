@@ -0,0 +1,235 @@
+// Package logsink writes a Prometheus registry to a log stream, for
+// pipelines that ingest metrics from stdout rather than scraping. It
+// periodically gathers the registry and writes one JSON line per metric
+// family.
+package logsink
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Config holds the configuration for the log sink exporter
+type Config struct {
+	// Writer receives the JSON lines, e.g. os.Stdout
+	Writer io.Writer
+	// Interval between two exports
+	Interval time.Duration
+	// Gatherer is read on every tick, usually the Config.Registry the
+	// Metrics were registered on
+	Gatherer prometheus.Gatherer
+	// Now returns the timestamp of the lines, time.Now if nil
+	Now func() time.Time
+}
+
+// Exporter periodically writes the gathered metrics as JSON lines such as
+//
+//	{"timestamp":"2024-01-02T15:04:05Z","name":"app_http_requests_total","type":"counter","help":"...","metrics":[{"labels":{"method":"GET"},"value":3}]}
+//
+// Counters, gauges and untyped metrics carry a value. Histograms carry
+// their count, sum and cumulative buckets keyed by upper bound, summaries
+// their count, sum and quantiles. Non-finite values are written as the
+// strings "NaN", "+Inf" and "-Inf".
+type Exporter struct {
+	cfg Config
+
+	mu sync.Mutex // serializes the writes of concurrent exports
+
+	stop      chan struct{}
+	done      chan struct{}
+	startOnce sync.Once
+	stopOnce  sync.Once
+}
+
+// NewExporter creates an exporter. Call Start to begin exporting.
+func NewExporter(cfg Config) (*Exporter, error) {
+	if cfg.Gatherer == nil {
+		return nil, fmt.Errorf("logsink: a Gatherer is required")
+	}
+	if cfg.Writer == nil {
+		return nil, fmt.Errorf("logsink: a Writer is required")
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = 10 * time.Second
+	}
+	if cfg.Now == nil {
+		cfg.Now = time.Now
+	}
+
+	return &Exporter{
+		cfg:  cfg,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}, nil
+}
+
+// Start runs the export loop in a background goroutine. Calls after the
+// first, or after Stop, do nothing.
+func (e *Exporter) Start() {
+	e.startOnce.Do(func() {
+		go func() {
+			defer close(e.done)
+
+			ticker := time.NewTicker(e.cfg.Interval)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-ticker.C:
+					_ = e.Export()
+				case <-e.stop:
+					return
+				}
+			}
+		}()
+	})
+}
+
+// Stop ends the export loop if it was started and performs a final
+// export. It is safe to call more than once.
+func (e *Exporter) Stop() {
+	e.stopOnce.Do(func() {
+		// Without a loop to close done, mark it as finished ourselves
+		e.startOnce.Do(func() { close(e.done) })
+		close(e.stop)
+		<-e.done
+		_ = e.Export()
+	})
+}
+
+// Export gathers the metrics once and writes them. It is called on every
+// tick but can also be used directly to flush on demand.
+func (e *Exporter) Export() error {
+	families, err := e.cfg.Gatherer.Gather()
+	if err != nil && len(families) == 0 {
+		return fmt.Errorf("logsink: gather: %w", err)
+	}
+
+	timestamp := e.cfg.Now().UTC()
+	var buf []byte
+	for _, mf := range families {
+		line, err := json.Marshal(newFamily(timestamp, mf))
+		if err != nil {
+			return fmt.Errorf("logsink: encode %s: %w", mf.GetName(), err)
+		}
+		buf = append(append(buf, line...), '\n')
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, err := e.cfg.Writer.Write(buf); err != nil {
+		return fmt.Errorf("logsink: write: %w", err)
+	}
+	return nil
+}
+
+// family is the JSON line of a metric family
+type family struct {
+	Timestamp time.Time `json:"timestamp"`
+	Name      string    `json:"name"`
+	Type      string    `json:"type"`
+	Help      string    `json:"help,omitempty"`
+	Metrics   []metric  `json:"metrics"`
+}
+
+type metric struct {
+	Labels    map[string]string `json:"labels,omitempty"`
+	Value     *float            `json:"value,omitempty"`
+	Count     *uint64           `json:"count,omitempty"`
+	Sum       *float            `json:"sum,omitempty"`
+	Buckets   map[string]uint64 `json:"buckets,omitempty"`
+	Quantiles map[string]float  `json:"quantiles,omitempty"`
+}
+
+func newFamily(timestamp time.Time, mf *dto.MetricFamily) family {
+	f := family{
+		Timestamp: timestamp,
+		Name:      mf.GetName(),
+		Type:      typeName(mf.GetType()),
+		Help:      mf.GetHelp(),
+		Metrics:   make([]metric, 0, len(mf.GetMetric())),
+	}
+
+	for _, m := range mf.GetMetric() {
+		var out metric
+		if len(m.GetLabel()) > 0 {
+			out.Labels = make(map[string]string, len(m.GetLabel()))
+			for _, lp := range m.GetLabel() {
+				out.Labels[lp.GetName()] = lp.GetValue()
+			}
+		}
+
+		switch mf.GetType() {
+		case dto.MetricType_COUNTER:
+			out.Value = newFloat(m.GetCounter().GetValue())
+		case dto.MetricType_GAUGE:
+			out.Value = newFloat(m.GetGauge().GetValue())
+		case dto.MetricType_UNTYPED:
+			out.Value = newFloat(m.GetUntyped().GetValue())
+		case dto.MetricType_HISTOGRAM, dto.MetricType_GAUGE_HISTOGRAM:
+			h := m.GetHistogram()
+			count := h.GetSampleCount()
+			out.Count, out.Sum = &count, newFloat(h.GetSampleSum())
+			out.Buckets = make(map[string]uint64, len(h.GetBucket())+1)
+			for _, b := range h.GetBucket() {
+				out.Buckets[formatFloat(b.GetUpperBound())] = b.GetCumulativeCount()
+			}
+			out.Buckets["+Inf"] = count
+		case dto.MetricType_SUMMARY:
+			s := m.GetSummary()
+			count := s.GetSampleCount()
+			out.Count, out.Sum = &count, newFloat(s.GetSampleSum())
+			out.Quantiles = make(map[string]float, len(s.GetQuantile()))
+			for _, q := range s.GetQuantile() {
+				out.Quantiles[formatFloat(q.GetQuantile())] = float(q.GetValue())
+			}
+		}
+		f.Metrics = append(f.Metrics, out)
+	}
+	return f
+}
+
+func typeName(t dto.MetricType) string {
+	switch t {
+	case dto.MetricType_COUNTER:
+		return "counter"
+	case dto.MetricType_GAUGE:
+		return "gauge"
+	case dto.MetricType_HISTOGRAM:
+		return "histogram"
+	case dto.MetricType_GAUGE_HISTOGRAM:
+		return "gaugehistogram"
+	case dto.MetricType_SUMMARY:
+		return "summary"
+	}
+	return "untyped"
+}
+
+// float is a float64 that encodes non-finite values as strings, which
+// JSON numbers can't represent
+type float float64
+
+func newFloat(f float64) *float {
+	v := float(f)
+	return &v
+}
+
+func (f float) MarshalJSON() ([]byte, error) {
+	v := float64(f)
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return json.Marshal(formatFloat(v))
+	}
+	return json.Marshal(v)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
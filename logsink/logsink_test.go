@@ -0,0 +1,51 @@
+package logsink
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestStopWithoutStart(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	c := prometheus.NewCounter(prometheus.CounterOpts{Name: "jobs_total", Help: "Jobs"})
+	reg.MustRegister(c)
+	c.Add(3)
+
+	var buf bytes.Buffer
+	e, err := NewExporter(Config{Writer: &buf, Gatherer: reg, Interval: time.Hour})
+	if err != nil {
+		t.Fatalf("NewExporter: %v", err)
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		e.Stop()
+		e.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stop blocked without Start")
+	}
+
+	// Stop still performs the final export
+	var line struct {
+		Name    string `json:"name"`
+		Type    string `json:"type"`
+		Metrics []struct {
+			Value float64 `json:"value"`
+		} `json:"metrics"`
+	}
+	if err := json.Unmarshal([]byte(strings.TrimSpace(buf.String())), &line); err != nil {
+		t.Fatalf("decode %q: %v", buf.String(), err)
+	}
+	if line.Name != "jobs_total" || line.Type != "counter" || len(line.Metrics) != 1 || line.Metrics[0].Value != 3 {
+		t.Errorf("line = %+v", line)
+	}
+}
@@ -1,8 +1,15 @@
 package prommonitoring
 
 import (
+	"context"
+	"log"
+	"math/rand/v2"
 	"net/http"
+	"regexp"
+	"slices"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -10,101 +17,644 @@ import (
 
 // Config holds the configuration for the Prometheus monitoring setup
 type Config struct {
+	// Namespace prefixes all metric names. It may be empty, in which case
+	// the metrics are exposed unprefixed (e.g. http_requests_total), for
+	// setups that add the prefix by relabeling at scrape time.
 	Namespace   string
 	MetricsPath string
-	Registry    *prometheus.Registry
+
+	// Registry is the registry the metrics are registered on, exactly
+	// once. When nil, InitMetrics and MetricsHandler create a registry and
+	// assign it here; the global default registry is never used.
+	Registry *prometheus.Registry
+
+	// EnableRequestID registers the http_request_id_generated_total counter
+	// used by RequestIDMiddleware
+	EnableRequestID bool
+
+	// ExemplarFromContext, when set, is called at the end of each request
+	// and the returned labels are attached as exemplars to the request
+	// counter and duration histogram. See TraceExemplar.
+	ExemplarFromContext func(ctx context.Context) prometheus.Labels
+
+	// GenerateTraceparent makes TraceparentMiddleware start a new trace
+	// for requests without a valid traceparent header
+	GenerateTraceparent bool
+
+	// SlowRequestThreshold is the duration above which a request is
+	// considered slow
+	SlowRequestThreshold time.Duration
+
+	// SlowRequestDiagnostics records the goroutine count and heap
+	// allocations of requests exceeding SlowRequestThreshold. Reading the
+	// memory statistics stops the world, so it is only done once a request
+	// is already slow.
+	SlowRequestDiagnostics bool
+
+	// UnknownPathLabel is the path label used for requests with an empty
+	// path. Defaults to "unknown".
+	UnknownPathLabel string
+
+	// ConnectPathLabel is the path label of CONNECT requests, whose target
+	// is a host:port rather than a path. Defaults to "<connect>".
+	ConnectPathLabel string
+
+	// AsteriskPathLabel is the path label of "OPTIONS *" requests.
+	// Defaults to "*".
+	AsteriskPathLabel string
+
+	// MaxConcurrent enables ConcurrencyLimitMiddleware, bounding the number
+	// of requests executing at once. It also registers
+	// http_saturation_ratio, the number of requests holding a slot divided
+	// by this maximum; queued requests don't count.
+	MaxConcurrent int
+
+	// MaxQueued is the number of requests allowed to wait for a slot when
+	// MaxConcurrent is reached; further requests are rejected with 503
+	MaxQueued int
+
+	// QueueTimeout bounds the time a request waits for a slot before it is
+	// rejected with 503. Zero waits until the client goes away.
+	QueueTimeout time.Duration
+
+	// Profile presets the label configuration. Defaults to ProfileDetailed;
+	// ProfileAggregate drops the path label from all metrics.
+	Profile Profile
+
+	// DisablePathLabel drops the path label from all metrics
+	DisablePathLabel bool
+
+	// MeasureBodyReadTime records the time between the first and the last
+	// read of the request body, separating slow uploads from slow handlers
+	MeasureBodyReadTime bool
+
+	// SanitizeLabels replaces invalid UTF-8 and control characters in
+	// request-derived label values such as the path
+	SanitizeLabels bool
+
+	// NotFoundHandling controls whether 404s are counted as client errors
+	// (the default), under a separate "not_found" error type, or not at all
+	NotFoundHandling NotFoundHandling
+
+	// CountRedirects registers http_redirects_total, counting redirect
+	// responses by status code
+	CountRedirects bool
+
+	// DurationByStatusClass labels the duration histogram by status class
+	// (2xx, 4xx, ...) instead of the exact status code, which is kept on the
+	// request counter
+	DurationByStatusClass bool
+
+	// DisableCompression turns off gzip negotiation on the metrics endpoint
+	DisableCompression bool
+
+	// NoStore sets "Cache-Control: no-store" on the metrics endpoint so
+	// intermediaries never cache the exposition
+	NoStore bool
+
+	// ConditionalScrapes sets an ETag on the metrics endpoint and answers
+	// 304 Not Modified when the exposition hasn't changed since the
+	// scraper's previous request
+	ConditionalScrapes bool
+
+	// DetailedErrorsOnly records successful (1xx-3xx) requests in the
+	// counters only, skipping their duration and size observations. Error
+	// responses update the full set, and request totals stay exact.
+	DetailedErrorsOnly bool
+
+	// ErrorTypeByStatus maps error status codes to named error types, e.g.
+	// 429 to "rate_limited". Unmapped codes are counted as client_error or
+	// server_error.
+	ErrorTypeByStatus map[int]string
+
+	// CountSuccesses registers http_success_total, counting the responses
+	// SuccessStatus accepts, so success-rate SLOs are a ratio of counters
+	CountSuccesses bool
+
+	// SuccessStatus reports whether a status is a success, e.g. to also
+	// count expected 404s. Defaults to DefaultSuccessStatus (2xx and 3xx).
+	SuccessStatus func(statusCode int) bool
+
+	// PathLabelMode selects how the path label is derived from the request
+	// path: the full path (default), its first segment, its depth, the
+	// matched ServeMux pattern or the handler's operation ID
+	PathLabelMode PathLabelMode
+
+	// PathPipeline rewrites the path label in order after PathLabelMode,
+	// e.g. []PathTransform{NormalizeIDs, CollapseToSegments(3),
+	// CapCardinality(200)}
+	PathPipeline []PathTransform
+
+	// NormalizeTrailingSlash strips a trailing slash from the path label,
+	// except for "/", so that /users and /users/ share a series. Leave it
+	// off if the application routes them differently.
+	NormalizeTrailingSlash bool
+
+	// TrackStreaming counts flushes and records the time to first byte of
+	// responses whose handler calls Flush, e.g. SSE endpoints
+	TrackStreaming bool
+
+	// WrapResponseWriter replaces the built-in response writer wrapper,
+	// e.g. to reuse a framework's own recorder instead of wrapping twice
+	// and losing its optional interfaces. Features that depend on the
+	// built-in wrapper, such as TrackStreaming, are unavailable with it.
+	WrapResponseWriter func(http.ResponseWriter) ResponseRecorder
+
+	// APIVersionPattern enables the api_version label on the request
+	// counter. Its first capture group extracts the version from the
+	// APIVersionHeader, e.g. `vnd\.myapp\.(v\d+)\+json`.
+	APIVersionPattern *regexp.Regexp
+
+	// APIVersionHeader is the header the API version is read from.
+	// Defaults to "Accept".
+	APIVersionHeader string
+
+	// APIVersions is the allowlist of api_version values. Anything else is
+	// labelled "unknown".
+	APIVersions []string
+
+	// DurationBuckets overrides the buckets of the request duration
+	// histogram. See LatencyBucketsForSLO and LinearLatencyBuckets.
+	DurationBuckets []float64
+
+	// AdditionalDurationBuckets adds boundaries, e.g. 15 and 30 for slow
+	// endpoints, to the default duration buckets (or DurationBuckets)
+	// instead of replacing them. The result is sorted and deduplicated.
+	AdditionalDurationBuckets []float64
+
+	// ClientClassRules enables the client_class label on the request
+	// counter, classifying requests by User-Agent (e.g. probe, bot,
+	// browser). Requests matching no rule are labelled "other". See
+	// DefaultClientClassRules.
+	ClientClassRules []ClientClassRule
+
+	// TrackMaxResponseSize registers http_response_size_max_bytes, the
+	// largest response per method and path since the previous scrape. The
+	// maxima reset on every scrape; see MaxGaugeVec.
+	TrackMaxResponseSize bool
+
+	// CountStatusByMethod registers http_requests_by_status_method, a
+	// per-method variant of http_requests_by_status without the path
+	CountStatusByMethod bool
+
+	// DurationMilliseconds registers http_request_duration_milliseconds
+	// alongside the seconds histogram, as a drop-in for dashboards built on
+	// millisecond latencies. Seconds remain the recommended unit.
+	DurationMilliseconds bool
+
+	// DurationMillisecondsBuckets overrides the buckets of the milliseconds
+	// histogram
+	DurationMillisecondsBuckets []float64
+
+	// SizeKiB registers http_request_size_kibibytes and
+	// http_response_size_kibibytes alongside the bytes histograms, for
+	// dashboards built on KiB sizes. Bytes remain the recommended unit.
+	SizeKiB bool
+
+	// SizeKiBBuckets overrides the buckets of the KiB histograms
+	SizeKiBBuckets []float64
+
+	// DurationSummary registers http_request_duration_summary_seconds, a
+	// summary with only p50, p95 and p99 over the last hour. Its few
+	// series per label set are cheap to keep for a year, so long-term
+	// downsampling can drop the histogram buckets and keep it. Quantiles
+	// can't be aggregated across instances, which the histogram remains
+	// needed for.
+	DurationSummary bool
+
+	// RecoverResponseBody replaces the plain-text body RecoverMiddleware
+	// sends after a panic, e.g. []byte(`{"error":"internal"}`)
+	RecoverResponseBody []byte
+
+	// RecoverContentType is the Content-Type of RecoverResponseBody, e.g.
+	// "application/json". Detected from the body when empty.
+	RecoverContentType string
+
+	// RecoverStatusCode is the status sent after a panic. Defaults to 500.
+	RecoverStatusCode int
+
+	// BotPatterns enables the is_bot label on the request counter, set to
+	// "true" when the User-Agent contains one of these substrings
+	// (case-insensitively). See DefaultBotPatterns.
+	BotPatterns []string
+
+	// RequestSizeFromBody observes the number of request body bytes the
+	// handler actually read instead of the declared Content-Length, so
+	// bodies that were never processed aren't counted
+	RequestSizeFromBody bool
+
+	// RequestSizeMethods restricts the request size observation to these
+	// methods, e.g. DefaultBodyMethods(). All methods when nil.
+	RequestSizeMethods []string
+
+	// ExcludePaths lists request paths Middleware passes through without
+	// recording, e.g. health checks or the metrics endpoint itself
+	ExcludePaths []string
+
+	// LargeResponseThreshold registers http_large_responses_total, counting
+	// responses whose body exceeds this many bytes. Disabled when zero.
+	LargeResponseThreshold int64
+
+	// ConstLabels are added to every metric of this package
+	ConstLabels prometheus.Labels
+
+	// DeploymentLabel tags every metric with the deployment it comes from,
+	// e.g. DeploymentLabels("canary"), to compare canary and stable in
+	// PromQL with "by (deployment)". Merged into ConstLabels.
+	DeploymentLabel map[string]string
+
+	// MeasureOverhead registers http_middleware_overhead_seconds, the time
+	// spent before and after the handler by this package's middleware. It
+	// is a diagnostic and can't see the time of other middlewares.
+	MeasureOverhead bool
+
+	// TrackConnections registers the connection metrics maintained by
+	// InstrumentServer
+	TrackConnections bool
+
+	// TrackTLSHandshakeErrors registers tls_handshake_errors_total,
+	// counting the failed handshakes of servers passed to
+	// InstrumentServer by reason (certificate, protocol_mismatch,
+	// timeout, eof, ...)
+	TrackTLSHandshakeErrors bool
+
+	// CountEmptyResponses registers http_empty_responses_total, counting
+	// handlers that returned without writing a header or body. Requires
+	// the built-in response writer wrapper.
+	CountEmptyResponses bool
+
+	// DisableSizePathLabel drops the path label from the request and
+	// response size histograms only, which are the most expensive in
+	// series, while keeping it on the counter and duration histogram
+	DisableSizePathLabel bool
+
+	// ServiceLabel adds a service label to the request counter and the
+	// duration histogram, set by routing layers with WithService. Meant
+	// for gateways proxying to a small, fixed set of upstreams; empty for
+	// requests that were not routed.
+	ServiceLabel bool
+
+	// MeasureScrapeDuration makes MetricsHandler record how long each
+	// scrape takes to generate in http_metrics_scrape_duration_seconds,
+	// kept on a registry of its own and exposed with the others
+	MeasureScrapeDuration bool
+
+	// AttemptCountHeader registers http_upstream_attempt_count, observing
+	// the numeric value of this request header, e.g.
+	// "X-Envoy-Attempt-Count", to show retry amplification. Values above
+	// 20 are recorded as 20.
+	AttemptCountHeader string
+
+	// ClientClassifier enables the client_origin label on the request
+	// counter, set to its result, e.g. a country code or "datacenter"
+	// from a GeoIP or ASN lookup. The result MUST come from a small fixed
+	// set: every distinct value is a new series, so never return the IP
+	// itself. Wrap it with CapClientClassifier to enforce a bound.
+	ClientClassifier func(*http.Request) string
+
+	// OperationLabel adds an operation_id label to the request counter and
+	// the duration histogram, set by handlers with WithOperationID. Use
+	// PathLabelOperation instead to replace the path label. Requests
+	// without an ID are labelled UnknownPathLabel.
+	OperationLabel bool
+
+	// Outcomes registers http_request_outcome_total and lists the outcome
+	// names handlers may record with RequestRecorderFrom(ctx).IncOutcome.
+	// Other names are counted as "other".
+	Outcomes []string
+
+	// InstrumentNotFound makes SetupMetricsServer answer every path other
+	// than MetricsPath with an instrumented 404, counted under the
+	// "<unmatched>" path, so no request to its mux goes unrecorded
+	InstrumentNotFound bool
+
+	// CountBodyReadErrors counts requests whose body failed to read, e.g.
+	// on a client disconnect mid-upload or malformed chunked encoding, in
+	// http_errors_total with error_type "request_read_error", whatever
+	// status the handler responded with
+	CountBodyReadErrors bool
+
+	// MetricsHealthEndpoint serves a health check of the metrics setup at
+	// MetricsPath + "/health" from SetupMetricsServer and CombinedHandler
+	MetricsHealthEndpoint bool
+
+	// MetricsDescribeEndpoint serves the metric descriptors as JSON at
+	// MetricsPath + "/describe", see Metrics.Descriptors
+	MetricsDescribeEndpoint bool
+
+	// MetricsResetEndpoint serves MetricsPath + "/reset", which calls
+	// Metrics.Reset on POST, e.g. to zero the counters between load test
+	// runs. Resetting in production corrupts rate calculations, so it is
+	// off by default, and SetupMetricsServer and CombinedHandler refuse
+	// to mount it without middlewares, which must authenticate callers.
+	MetricsResetEndpoint bool
+
+	// Now replaces time.Now as the clock of the request timings, e.g.
+	// with a fake clock in tests: those of Middleware including body
+	// reads, the queue time of ConcurrencyLimitMiddleware and the
+	// duration of NotFoundHandler. Durations are computed on this clock
+	// alone and clamped to zero if it steps backwards.
+	Now func() time.Time
+
+	// CountTimeouts registers http_request_timeouts_total, counting the
+	// requests TimeoutMiddleware answered with 504
+	CountTimeouts bool
+
+	// CountBodyTooLarge registers http_request_body_too_large_total,
+	// counting the requests MaxBodyMiddleware answered with 413
+	CountBodyTooLarge bool
+
+	// OpenMetricsCreated adds the _created timestamp of every counter,
+	// histogram and summary to OpenMetrics scrapes, which promhttp omits,
+	// for tools that rely on it to compute rates across restarts
+	OpenMetricsCreated bool
+
+	// CountWhenDisabled keeps the counters of Middleware ticking while
+	// Metrics.SetEnabled(false) is in effect, skipping only the duration
+	// and size histograms
+	CountWhenDisabled bool
+
+	// TrackInFlightMax registers http_requests_in_flight_max{method,path},
+	// the peak concurrency of each route since the previous scrape, which
+	// reveals bursts the sampled in-flight gauge misses. Every scrape
+	// resets the peaks (see MaxGaugeVec) down to the routes' current
+	// concurrency, so long requests keep their route reported. The path
+	// is resolved when the request starts, before routers could annotate
+	// it, so PathLabelPattern and PathLabelOperation aren't reflected.
+	TrackInFlightMax bool
+
+	// TrackCompressionRatio registers http_response_compression_ratio,
+	// observed for responses with a Content-Encoding whose uncompressed
+	// size was reported with SetUncompressedSize
+	TrackCompressionRatio bool
+
+	// HistogramSampleRate observes the duration and size histograms for
+	// this fraction of requests only, e.g. 0.1, cutting their cost under
+	// high load. Counters still count every request, while the histogram
+	// _count and _sum cover the sampled requests. All requests are
+	// observed when zero or from 1 on.
+	HistogramSampleRate float64
+
+	// RandSource replaces the runtime's fast per-goroutine generator for
+	// the sampling decisions, e.g. with a seeded rand.NewPCG in tests to
+	// make them reproducible. Access to it is serialized.
+	RandSource rand.Source
+
+	// TrackBackendDuration registers http_backend_duration_seconds,
+	// recording the backend latency handlers report with
+	// RecordBackendLatency, using the duration buckets
+	TrackBackendDuration bool
+
+	// SecurityHeaders enables SecurityHeadersMiddleware, which counts the
+	// responses lacking any of these headers. See DefaultSecurityHeaders.
+	SecurityHeaders []string
+
+	// SecurityHeaderValues are set by SecurityHeadersMiddleware on
+	// responses missing the header, e.g.
+	// {"X-Content-Type-Options": "nosniff"}. Responses are left untouched
+	// when nil.
+	SecurityHeaderValues map[string]string
+
+	// RouterLabel adds a router label to the request counter and the
+	// duration histogram, naming the group given to MiddlewareForGroup.
+	// Requests instrumented with plain Middleware are labelled "default".
+	RouterLabel bool
+
+	// TrackHeaderSize registers http_response_header_size_bytes. The size
+	// is an estimate summing the header lines set by the handler, as the
+	// exact serialized size is only known to the server. It requires the
+	// built-in response writer wrapper.
+	TrackHeaderSize bool
+
+	// SNIHosts enables the sni label on the request counter, set to the
+	// TLS server name of the request when it is one of these hostnames
+	// and "other" otherwise. Unlike the Host header it can't differ from
+	// the certificate the client asked for. Empty for plaintext requests.
+	SNIHosts []string
+
+	// TrackRateLimits registers http_rate_limited_total, counting 429
+	// responses, and http_retry_after_seconds with the Retry-After they
+	// were sent with. Retry-After dates aren't observed.
+	TrackRateLimits bool
+
+	// IdempotentLabel adds an idempotent label ("true" or "false") to the
+	// request counter, derived from the method with IsIdempotentMethod
+	IdempotentLabel bool
+
+	// TrackCacheTTL registers http_response_cache_ttl_seconds with the
+	// Cache-Control max-age the handlers send, to spot inconsistent
+	// caching across endpoints. Responses without one are skipped.
+	TrackCacheTTL bool
+
+	// ColdStartLabel adds a cold_start label to the duration histogram,
+	// "true" only for the first request the process handles, to tell
+	// serverless cold starts apart from steady-state latency
+	ColdStartLabel bool
+
+	// FormatLabel adds a format label to the request counter, the format
+	// the handler negotiated as read from the response Content-Type:
+	// "json", "xml", "protobuf" or "other"
+	FormatLabel bool
 }
 
 // DefaultConfig returns a default configuration
 func DefaultConfig() *Config {
 	return &Config{
-		Namespace:   "app",
-		MetricsPath: "/metrics",
-		Registry:    prometheus.NewRegistry(),
+		Namespace:        "app",
+		MetricsPath:      "/metrics",
+		Registry:         prometheus.NewRegistry(),
+		UnknownPathLabel: DefaultUnknownPathLabel,
+		Profile:          ProfileDetailed,
+		PathLabelMode:    PathLabelFull,
 	}
 }
 
+// registry returns the registry the metrics belong on, creating it on
+// first use so that the metrics and the handler serving them share it
+func (cfg *Config) registry() *prometheus.Registry {
+	if cfg.Registry == nil {
+		cfg.Registry = prometheus.NewRegistry()
+	}
+	return cfg.Registry
+}
+
 var (
-	metrics     *Metrics
+	metrics     atomic.Pointer[Metrics]
 	metricsOnce sync.Once
+	// metricsErr is the error of the active instance, guarded by
+	// reconfigureMu since Reconfigure can clear it
+	metricsErr error
 )
 
-// InitMetrics initializes the Prometheus metrics with the given configuration
+// InitMetrics initializes the Prometheus metrics with the given configuration.
+// It panics if the metrics can't be registered; see InitMetricsE.
 func InitMetrics(cfg *Config) *Metrics {
+	m, err := InitMetricsE(cfg)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+// InitMetricsE initializes the Prometheus metrics with the given
+// configuration, returning a *RegistrationError naming the colliding metric
+// if registration fails
+func InitMetricsE(cfg *Config) (*Metrics, error) {
 	if cfg == nil {
 		cfg = DefaultConfig()
 	}
 
 	metricsOnce.Do(func() {
-		metrics = NewMetrics(cfg.Namespace)
-
-		// Register metrics with the registry
-		if cfg.Registry != nil {
-			cfg.Registry.MustRegister(
-				metrics.RequestCounter,
-				metrics.ResponseDuration,
-				metrics.RequestSize,
-				metrics.ResponseSize,
-				metrics.RequestsInFlight,
-				metrics.TotalErrors,
-				metrics.RequestsByStatus,
-			)
+		m, err := newMetricsE(cfg)
+		if err == nil {
+			err = m.register(cfg.registry())
 		}
+		reconfigureMu.Lock()
+		defer reconfigureMu.Unlock()
+		metrics.Store(m)
+		metricsErr = err
 	})
 
-	return metrics
+	reconfigureMu.Lock()
+	defer reconfigureMu.Unlock()
+	return metrics.Load(), metricsErr
+}
+
+// initError returns the error the active instance was initialized with
+func initError() error {
+	reconfigureMu.Lock()
+	defer reconfigureMu.Unlock()
+	return metricsErr
 }
 
 // GetMetrics returns the initialized metrics instance
 func GetMetrics() *Metrics {
-	if metrics == nil {
-		return InitMetrics(nil)
+	if m := metrics.Load(); m != nil {
+		return m
 	}
-	return metrics
+	return InitMetrics(nil)
 }
 
-// MetricsHandler returns a handler for exposing Prometheus metrics
+// MetricsHandler returns a handler for exposing Prometheus metrics. It
+// serves the registry InitMetrics registers on for the same cfg, creating
+// it if cfg.Registry is nil. A nil cfg stands for the configuration of the
+// instance GetMetrics returns, so that its registry is served.
 func MetricsHandler(cfg *Config) http.Handler {
 	if cfg == nil {
-		cfg = DefaultConfig()
-	}
-
-	// Create a custom registry if one isn't provided
-	if cfg.Registry == nil {
-		cfg.Registry = prometheus.NewRegistry()
+		cfg = GetMetrics().cfg
 	}
 
 	// Create handler options
 	handlerOpts := promhttp.HandlerOpts{
-		Registry:          cfg.Registry,
-		EnableOpenMetrics: true,
+		Registry:           cfg.registry(),
+		EnableOpenMetrics:  true,
+		DisableCompression: cfg.DisableCompression,
+	}
+
+	var gatherer prometheus.Gatherer = cfg.registry()
+	var scrape *scrapeDuration
+	if cfg.MeasureScrapeDuration {
+		scrape = newScrapeDuration(cfg)
+		gatherer = prometheus.Gatherers{gatherer, scrape.registry}
+	}
+
+	handler := promhttp.HandlerFor(gatherer, handlerOpts)
+	if cfg.OpenMetricsCreated {
+		handler = createdLinesHandler(gatherer, handler, cfg.DisableCompression)
+	}
+	if scrape != nil {
+		handler = scrape.middleware(handler)
 	}
 
-	return promhttp.HandlerFor(cfg.Registry, handlerOpts)
+	if cfg.ConditionalScrapes {
+		handler = conditionalMiddleware(handler)
+	}
+	if cfg.NoStore {
+		handler = noStoreMiddleware(handler)
+	}
+
+	return handler
+}
+
+// CombinedMetricsHandler serves the union of several gatherers, e.g. the
+// registry of the metrics along with one exposing a child process's
+// metrics. If some fail, the metrics gathered successfully are still
+// served and the errors logged with the standard logger, as promhttp does
+// with ContinueOnError.
+func CombinedMetricsHandler(gatherers ...prometheus.Gatherer) http.Handler {
+	return promhttp.HandlerFor(prometheus.Gatherers(gatherers), promhttp.HandlerOpts{
+		ErrorLog:          log.Default(),
+		ErrorHandling:     promhttp.ContinueOnError,
+		EnableOpenMetrics: true,
+	})
 }
 
-// SetupMetricsServer creates and configures a complete metrics server
+// SetupMetricsServer creates and configures a complete metrics server.
+// cfg.Registry is set to the registry the metrics are registered on, so
+// that the server never exposes a different one.
+// With Config.InstrumentNotFound, requests for any other path are answered
+// by the instrumented NotFoundHandler.
 func SetupMetricsServer(cfg *Config, middlewares ...func(http.Handler) http.Handler) *http.ServeMux {
 	if cfg == nil {
 		cfg = DefaultConfig()
 	}
 
-	// Initialize metrics
-	InitMetrics(cfg)
+	mux := setupMetricsMux(cfg, middlewares)
+	if cfg.InstrumentNotFound {
+		mux.Handle("/", GetMetrics().NotFoundHandler())
+	}
+
+	return mux
+}
+
+// setupMetricsMux initializes the metrics and mounts the metrics handler,
+// along with the enabled sub-endpoints. It panics if the reset endpoint is
+// enabled without middlewares to authenticate it.
+func setupMetricsMux(cfg *Config, middlewares []func(http.Handler) http.Handler) *http.ServeMux {
+	if cfg.MetricsResetEndpoint && len(middlewares) == 0 {
+		panic("prommonitoring: MetricsResetEndpoint requires an authentication middleware")
+	}
+
+	// Initialize metrics, and serve the registry they are registered on
+	// even if they were initialized before with another Config
+	m := InitMetrics(cfg)
+	cfg.Registry = m.cfg.Registry
 
 	// Create a new mux for metrics
 	mux := http.NewServeMux()
 
-	// Create the metrics handler
-	handler := MetricsHandler(cfg)
+	for path, handler := range metricsEndpoints(cfg, m) {
+		// Apply any additional middlewares
+		for _, middleware := range middlewares {
+			handler = middleware(handler)
+		}
+
+		// Register the metrics handler
+		mux.Handle(path, handler)
+	}
 
-	// Apply any additional middlewares
-	for _, middleware := range middlewares {
-		handler = middleware(handler)
+	return mux
+}
+
+// CombinedHandler serves the metrics endpoint and the application on a
+// single port: cfg.MetricsPath is answered by the metrics handler and
+// every other path by appHandler, which should already be instrumented.
+// The metrics paths are added to cfg.ExcludePaths so that scrapes stay out
+// of the request metrics even if the combined handler is wrapped with
+// Middleware.
+func CombinedHandler(cfg *Config, appHandler http.Handler, middlewares ...func(http.Handler) http.Handler) http.Handler {
+	if cfg == nil {
+		cfg = DefaultConfig()
+	}
+
+	for _, path := range metricsEndpointPaths(cfg) {
+		if !slices.Contains(cfg.ExcludePaths, path) {
+			cfg.ExcludePaths = append(cfg.ExcludePaths, path)
+		}
 	}
 
-	// Register the metrics handler
-	mux.Handle(cfg.MetricsPath, handler)
+	mux := setupMetricsMux(cfg, middlewares)
+	mux.Handle("/", appHandler)
 
 	return mux
 }
@@ -0,0 +1,78 @@
+package prommonitoring
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// scrape fetches path from h and parses the text exposition
+func scrape(t *testing.T, h http.Handler, path string) map[string]*dto.MetricFamily {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET %s: status %d", path, rec.Code)
+	}
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(rec.Body)
+	if err != nil {
+		t.Fatalf("parsing %s: %v", path, err)
+	}
+	return families
+}
+
+// scrapedRequests sums the samples of a scraped requests counter
+func scrapedRequests(families map[string]*dto.MetricFamily, name string) float64 {
+	var total float64
+	for _, m := range families[name].GetMetric() {
+		total += m.GetCounter().GetValue()
+	}
+	return total
+}
+
+func TestMetricsHandlerServesActiveRegistry(t *testing.T) {
+	for name, cfg := range map[string]*Config{
+		"nil config":   nil,
+		"nil registry": {Namespace: "app", MetricsPath: "/metrics"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			resetGlobalMetrics(t)
+
+			m := InitMetrics(cfg)
+			serveOne(m)
+
+			families := scrape(t, MetricsHandler(cfg), "/metrics")
+			if got := scrapedRequests(families, "app_http_requests_total"); got != 1 {
+				t.Errorf("scraped app_http_requests_total = %v, want 1", got)
+			}
+		})
+	}
+}
+
+func TestSetupMetricsServerServesMiddlewareRequests(t *testing.T) {
+	for name, setup := range map[string]func() *Config{
+		"nil registry": func() *Config {
+			return &Config{Namespace: "app", MetricsPath: "/metrics"}
+		},
+		"initialized before": func() *Config {
+			InitMetrics(&Config{Namespace: "app", MetricsPath: "/metrics"})
+			return &Config{Namespace: "app", MetricsPath: "/metrics"}
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			resetGlobalMetrics(t)
+
+			server := SetupMetricsServer(setup())
+			GetMetrics().Middleware(http.NotFoundHandler()).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/x", nil))
+
+			families := scrape(t, server, "/metrics")
+			if got := scrapedRequests(families, "app_http_requests_total"); got == 0 {
+				t.Error("app_http_requests_total is zero or missing from the scrape")
+			}
+		})
+	}
+}
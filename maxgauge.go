@@ -0,0 +1,94 @@
+package prommonitoring
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// MaxGaugeVec is a gauge collector tracking the maximum value observed per
+// label set since the previous scrape. Collect reports the maxima and then
+// resets them, so each scrape sees the peak of its own interval and series
+// without new observations disappear until observed again.
+//
+// With several scrapers (e.g. an HA Prometheus pair) every scrape resets
+// the maxima, so each scraper only sees the peaks since whichever scrape
+// came last.
+type MaxGaugeVec struct {
+	desc *prometheus.Desc
+
+	mu     sync.Mutex
+	maxima map[string]*maxGaugeEntry
+
+	// seed, if set, reports the current values when the maxima are reset,
+	// so that a level still held carries over into the next interval
+	seed func(observe func(value float64, labelValues ...string))
+}
+
+type maxGaugeEntry struct {
+	labelValues []string
+	value       float64
+}
+
+// NewMaxGaugeVec creates a MaxGaugeVec
+func NewMaxGaugeVec(opts prometheus.GaugeOpts, labelNames []string) *MaxGaugeVec {
+	return &MaxGaugeVec{
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(opts.Namespace, opts.Subsystem, opts.Name),
+			opts.Help,
+			labelNames,
+			opts.ConstLabels,
+		),
+		maxima: make(map[string]*maxGaugeEntry),
+	}
+}
+
+// Observe raises the maximum of the label set to value if it is higher
+func (v *MaxGaugeVec) Observe(value float64, labelValues ...string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.observe(value, labelValues...)
+}
+
+func (v *MaxGaugeVec) observe(value float64, labelValues ...string) {
+	key := strings.Join(labelValues, "\xff")
+	entry, ok := v.maxima[key]
+	if !ok {
+		v.maxima[key] = &maxGaugeEntry{labelValues: labelValues, value: value}
+		return
+	}
+	if value > entry.value {
+		entry.value = value
+	}
+}
+
+// Describe implements prometheus.Collector
+func (v *MaxGaugeVec) Describe(ch chan<- *prometheus.Desc) {
+	ch <- v.desc
+}
+
+// Collect implements prometheus.Collector, resetting the maxima
+func (v *MaxGaugeVec) Collect(ch chan<- prometheus.Metric) {
+	v.mu.Lock()
+	maxima := v.maxima
+	v.maxima = make(map[string]*maxGaugeEntry, len(maxima))
+	if v.seed != nil {
+		v.seed(v.observe)
+	}
+	v.mu.Unlock()
+
+	for _, entry := range maxima {
+		ch <- prometheus.MustNewConstMetric(v.desc, prometheus.GaugeValue, entry.value, entry.labelValues...)
+	}
+}
+
+// Reset drops all maxima, down to the current values if seeded
+func (v *MaxGaugeVec) Reset() {
+	v.mu.Lock()
+	v.maxima = make(map[string]*maxGaugeEntry)
+	if v.seed != nil {
+		v.seed(v.observe)
+	}
+	v.mu.Unlock()
+}
@@ -2,11 +2,13 @@ package prommonitoring
 
 import (
 	"net/http"
+	"slices"
 	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promauto"
 )
 
 // Metrics holds all Prometheus metrics for the HTTP service
@@ -18,153 +20,1391 @@ type Metrics struct {
 	RequestsInFlight *prometheus.GaugeVec
 	TotalErrors      *prometheus.CounterVec
 	RequestsByStatus *prometheus.CounterVec
+
+	// RequestIDGenerated counts request IDs created by RequestIDMiddleware,
+	// nil unless Config.EnableRequestID is set
+	RequestIDGenerated prometheus.Counter
+
+	// SlowRequestGoroutines and SlowRequestHeapAlloc record runtime
+	// statistics for slow requests, nil unless Config.SlowRequestDiagnostics
+	// is set
+	SlowRequestGoroutines *prometheus.HistogramVec
+	SlowRequestHeapAlloc  *prometheus.HistogramVec
+
+	// RequestQueueDuration and RequestsRejected are updated by
+	// ConcurrencyLimitMiddleware, nil unless Config.MaxConcurrent is set
+	RequestQueueDuration prometheus.Histogram
+	RequestsRejected     *prometheus.CounterVec
+
+	// RequestBodyReadDuration records the time handlers spent reading the
+	// request body, nil unless Config.MeasureBodyReadTime is set
+	RequestBodyReadDuration *prometheus.HistogramVec
+
+	// Redirects counts 301/302/303/307/308 responses, nil unless
+	// Config.CountRedirects is set
+	Redirects *prometheus.CounterVec
+
+	// SaturationRatio is the ratio of occupied ConcurrencyLimitMiddleware
+	// slots to Config.MaxConcurrent, nil unless MaxConcurrent is set
+	SaturationRatio prometheus.Gauge
+
+	// ResponseFlushes and TimeToFirstByte track streaming responses, i.e.
+	// those whose handler called Flush, nil unless Config.TrackStreaming is
+	// set
+	ResponseFlushes *prometheus.CounterVec
+	TimeToFirstByte *prometheus.HistogramVec
+
+	// ResponseSizeMax tracks the largest response per route since the
+	// previous scrape, nil unless Config.TrackMaxResponseSize is set
+	ResponseSizeMax *MaxGaugeVec
+
+	// RequestsByStatusMethod is RequestsByStatus with a method label, nil
+	// unless Config.CountStatusByMethod is set
+	RequestsByStatusMethod *prometheus.CounterVec
+
+	// ResponseDurationMilliseconds mirrors ResponseDuration in
+	// milliseconds for dashboards migrated from millisecond-based systems,
+	// nil unless Config.DurationMilliseconds is set
+	ResponseDurationMilliseconds *prometheus.HistogramVec
+
+	// RequestSizeKiB and ResponseSizeKiB mirror RequestSize and
+	// ResponseSize in KiB for dashboards migrated from KiB-based systems,
+	// nil unless Config.SizeKiB is set
+	RequestSizeKiB  *prometheus.HistogramVec
+	ResponseSizeKiB *prometheus.HistogramVec
+
+	// ResponseDurationSummary exposes p50/p95/p99 of the request duration
+	// for long-term storage, nil unless Config.DurationSummary is set
+	ResponseDurationSummary *prometheus.SummaryVec
+
+	// LargeResponses counts responses larger than
+	// Config.LargeResponseThreshold, nil unless the threshold is set
+	LargeResponses *prometheus.CounterVec
+
+	// MiddlewareOverhead records the time Middleware spends on its own
+	// bookkeeping, nil unless Config.MeasureOverhead is set
+	MiddlewareOverhead prometheus.Histogram
+
+	// Connections and ConnectionsTotal track the connections of servers
+	// passed to InstrumentServer, nil unless Config.TrackConnections is set
+	Connections      *prometheus.GaugeVec
+	ConnectionsTotal prometheus.Counter
+
+	// EmptyResponses counts handlers that returned without calling
+	// WriteHeader, Write or Flush, nil unless Config.CountEmptyResponses is
+	// set
+	EmptyResponses *prometheus.CounterVec
+
+	// Successes counts the responses accepted by Config.SuccessStatus, nil
+	// unless Config.CountSuccesses is set
+	Successes *prometheus.CounterVec
+
+	// UpstreamAttempts records the attempt count a load balancer forwards
+	// in Config.AttemptCountHeader, nil unless the header is set
+	UpstreamAttempts *prometheus.HistogramVec
+
+	// Outcomes counts the outcomes handlers record through
+	// RequestRecorderFrom, nil unless Config.Outcomes is set
+	Outcomes *prometheus.CounterVec
+
+	// TLSHandshakeErrors counts the failed TLS handshakes of servers
+	// passed to InstrumentServer, nil unless
+	// Config.TrackTLSHandshakeErrors is set
+	TLSHandshakeErrors *prometheus.CounterVec
+
+	// RequestTimeouts counts the requests TimeoutMiddleware timed out,
+	// nil unless Config.CountTimeouts is set
+	RequestTimeouts *prometheus.CounterVec
+
+	// BodyTooLarge counts the requests MaxBodyMiddleware answered with
+	// 413, nil unless Config.CountBodyTooLarge is set
+	BodyTooLarge *prometheus.CounterVec
+
+	// RequestsInFlightMax tracks the peak number of concurrent requests
+	// per route between two scrapes, nil unless
+	// Config.TrackInFlightMax is set
+	RequestsInFlightMax *MaxGaugeVec
+
+	// CompressionRatio records the ratio of the uncompressed size reported
+	// with SetUncompressedSize to the bytes sent, nil unless
+	// Config.TrackCompressionRatio is set
+	CompressionRatio *prometheus.HistogramVec
+
+	// BackendDuration records the backend latency reported with
+	// RecordBackendLatency, nil unless Config.TrackBackendDuration is set
+	BackendDuration *prometheus.HistogramVec
+
+	// MissingSecurityHeaders counts the responses lacking one of
+	// Config.SecurityHeaders, nil unless it is set
+	MissingSecurityHeaders *prometheus.CounterVec
+
+	// ResponseHeaderSize records the estimated size of the response
+	// headers, nil unless Config.TrackHeaderSize is set
+	ResponseHeaderSize *prometheus.HistogramVec
+
+	// RateLimited counts 429 responses and RetryAfter records their
+	// numeric Retry-After, both nil unless Config.TrackRateLimits is set
+	RateLimited *prometheus.CounterVec
+	RetryAfter  *prometheus.HistogramVec
+
+	// ResponseCacheTTL records the Cache-Control max-age of responses,
+	// nil unless Config.TrackCacheTTL is set
+	ResponseCacheTTL *prometheus.HistogramVec
+
+	cfg      *Config
+	limiter  *concurrencyLimiter
+	inFlight atomic.Int64
+	disabled bool
+	paused   atomic.Bool
+
+	routeInFlight routeConcurrency
+	sampler       *sampler
+	observers     requestObservers
+
+	// registerer the metrics were registered on, nil if they weren't
+	registerer prometheus.Registerer
+	conns      connTracker
+
+	clientClassRules []ClientClassRule
+	botPatterns      []string
+	sniHosts         []string
+
+	// label names of the vectors, in the order their values are resolved
+	requestLabels  []string
+	durationLabels []string
+	sizeLabels     []string
+	routeLabels    []string
+	errorLabels    []string
+	pathLabels     []string
+	emptyLabels    []string
 }
 
-// NewMetrics creates and registers all Prometheus metrics
+// NewMetrics creates all Prometheus metrics without registering them
 func NewMetrics(namespace string) *Metrics {
-	return &Metrics{
-		RequestCounter: promauto.NewCounterVec(
+	return NewMetricsWithConfig(&Config{Namespace: namespace})
+}
+
+// NewMetricsWithConfig creates all Prometheus metrics, including the
+// optional ones enabled in the configuration. Nothing is registered:
+// register the returned Metrics as a collector, or use InitMetrics or
+// NewMetricsWithRegisterer.
+func NewMetricsWithConfig(cfg *Config) *Metrics {
+	if cfg == nil {
+		cfg = DefaultConfig()
+	}
+	namespace := cfg.Namespace
+	constLabels := cfg.constLabels()
+
+	durationStatus := "status"
+	if cfg.DurationByStatusClass {
+		durationStatus = "status_class"
+	}
+	sizeLabels := cfg.labelNames("method", "path")
+	if cfg.DisableSizePathLabel {
+		sizeLabels = cfg.labelNames("method")
+	}
+
+	m := &Metrics{
+		cfg:            cfg,
+		requestLabels:  cfg.labelNames("method", "path", "status", "api_version", "client_class", "is_bot", "service", "client_origin", "operation_id", "router", "sni", "idempotent", "format"),
+		durationLabels: cfg.labelNames("method", "path", durationStatus, "service", "operation_id", "router", "cold_start"),
+		sizeLabels:     sizeLabels,
+		routeLabels:    cfg.labelNames("method", "path"),
+		errorLabels:    cfg.labelNames("method", "path", "error_type"),
+		pathLabels:     cfg.labelNames("path"),
+		emptyLabels:    cfg.labelNames("method", "path", "status"),
+
+		clientClassRules: lowerClientClassRules(cfg.ClientClassRules),
+		sampler:          newSampler(cfg.RandSource),
+		sniHosts:         lowerStrings(cfg.SNIHosts),
+		botPatterns:      lowerStrings(cfg.BotPatterns),
+	}
+
+	m.RequestCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace:   namespace,
+			ConstLabels: constLabels,
+			Name:        "http_requests_total",
+			Help:        "Total number of HTTP requests",
+		},
+		m.requestLabels,
+	)
+	m.ResponseDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace:   namespace,
+			ConstLabels: constLabels,
+			Name:        "http_request_duration_seconds",
+			Help:        "HTTP request latency in seconds",
+			Buckets:     cfg.durationBuckets(),
+		},
+		m.durationLabels,
+	)
+	m.RequestSize = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace:   namespace,
+			ConstLabels: constLabels,
+			Name:        "http_request_size_bytes",
+			Help:        "HTTP request size in bytes",
+			Buckets:     prometheus.ExponentialBuckets(100, 10, 8),
+		},
+		m.sizeLabels,
+	)
+	m.ResponseSize = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace:   namespace,
+			ConstLabels: constLabels,
+			Name:        "http_response_size_bytes",
+			Help:        "HTTP response size in bytes",
+			Buckets:     prometheus.ExponentialBuckets(100, 10, 8),
+		},
+		m.sizeLabels,
+	)
+	m.RequestsInFlight = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace:   namespace,
+			ConstLabels: constLabels,
+			Name:        "http_requests_in_flight",
+			Help:        "Current number of HTTP requests being processed",
+		},
+		[]string{"method"},
+	)
+	m.TotalErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace:   namespace,
+			ConstLabels: constLabels,
+			Name:        "http_errors_total",
+			Help:        "Total number of HTTP errors",
+		},
+		m.errorLabels,
+	)
+	m.RequestsByStatus = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace:   namespace,
+			ConstLabels: constLabels,
+			Name:        "http_requests_by_status",
+			Help:        "HTTP requests partitioned by status code",
+		},
+		[]string{"status_class", "status_code"},
+	)
+
+	if cfg.EnableRequestID {
+		m.RequestIDGenerated = prometheus.NewCounter(
 			prometheus.CounterOpts{
-				Namespace: namespace,
-				Name:      "http_requests_total",
-				Help:      "Total number of HTTP requests",
+				Namespace:   namespace,
+				ConstLabels: constLabels,
+				Name:        "http_request_id_generated_total",
+				Help:        "Total number of requests that arrived without a request ID",
 			},
-			[]string{"method", "path", "status"},
-		),
-		ResponseDuration: promauto.NewHistogramVec(
+		)
+	}
+
+	if cfg.SlowRequestDiagnostics && cfg.SlowRequestThreshold > 0 {
+		m.SlowRequestGoroutines = prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace:   namespace,
+				ConstLabels: constLabels,
+				Name:        "http_slow_request_goroutines",
+				Help:        "Number of goroutines when a slow HTTP request completed",
+				Buckets:     prometheus.ExponentialBuckets(16, 2, 12),
+			},
+			m.pathLabels,
+		)
+		m.SlowRequestHeapAlloc = prometheus.NewHistogramVec(
 			prometheus.HistogramOpts{
-				Namespace: namespace,
-				Name:      "http_request_duration_seconds",
-				Help:      "HTTP request latency in seconds",
-				Buckets:   []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
-			},
-			[]string{"method", "path", "status"},
-		),
-		RequestSize: promauto.NewHistogramVec(
+				Namespace:   namespace,
+				ConstLabels: constLabels,
+				Name:        "http_slow_request_heap_alloc_bytes",
+				Help:        "Heap bytes allocated by the process while a slow HTTP request was running",
+				Buckets:     prometheus.ExponentialBuckets(1024, 4, 12),
+			},
+			m.pathLabels,
+		)
+	}
+
+	if cfg.MaxConcurrent > 0 {
+		m.limiter = newConcurrencyLimiter(cfg)
+		m.SaturationRatio = prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Namespace:   namespace,
+				ConstLabels: constLabels,
+				Name:        "http_saturation_ratio",
+				Help:        "Ratio of HTTP requests holding a concurrency slot to the configured maximum",
+			},
+		)
+		m.RequestQueueDuration = prometheus.NewHistogram(
+			prometheus.HistogramOpts{
+				Namespace:   namespace,
+				ConstLabels: constLabels,
+				Name:        "http_request_queue_seconds",
+				Help:        "Time HTTP requests spent waiting for a concurrency slot",
+				Buckets:     []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+			},
+		)
+		m.RequestsRejected = prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace:   namespace,
+				ConstLabels: constLabels,
+				Name:        "http_requests_rejected_total",
+				Help:        "Total number of HTTP requests rejected by the concurrency limiter",
+			},
+			[]string{"reason"},
+		)
+	}
+
+	if cfg.MeasureBodyReadTime {
+		m.RequestBodyReadDuration = prometheus.NewHistogramVec(
 			prometheus.HistogramOpts{
-				Namespace: namespace,
-				Name:      "http_request_size_bytes",
-				Help:      "HTTP request size in bytes",
-				Buckets:   prometheus.ExponentialBuckets(100, 10, 8),
-			},
-			[]string{"method", "path"},
-		),
-		ResponseSize: promauto.NewHistogramVec(
+				Namespace:   namespace,
+				ConstLabels: constLabels,
+				Name:        "http_request_body_read_seconds",
+				Help:        "Time between the first and the last read of the HTTP request body",
+				Buckets:     []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+			},
+			m.routeLabels,
+		)
+	}
+
+	if cfg.CountRedirects {
+		m.Redirects = prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace:   namespace,
+				ConstLabels: constLabels,
+				Name:        "http_redirects_total",
+				Help:        "Total number of HTTP redirect responses",
+			},
+			[]string{"from_status"},
+		)
+	}
+
+	if cfg.TrackStreaming {
+		m.ResponseFlushes = prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace:   namespace,
+				ConstLabels: constLabels,
+				Name:        "http_response_flush_total",
+				Help:        "Total number of flushes of streaming HTTP responses",
+			},
+			m.pathLabels,
+		)
+		m.TimeToFirstByte = prometheus.NewHistogramVec(
 			prometheus.HistogramOpts{
-				Namespace: namespace,
-				Name:      "http_response_size_bytes",
-				Help:      "HTTP response size in bytes",
-				Buckets:   prometheus.ExponentialBuckets(100, 10, 8),
-			},
-			[]string{"method", "path"},
-		),
-		RequestsInFlight: promauto.NewGaugeVec(
+				Namespace:   namespace,
+				ConstLabels: constLabels,
+				Name:        "http_time_to_first_byte_seconds",
+				Help:        "Time until the first byte of a streaming HTTP response was written or flushed",
+				Buckets:     []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+			},
+			m.pathLabels,
+		)
+	}
+
+	if cfg.TrackMaxResponseSize {
+		m.ResponseSizeMax = NewMaxGaugeVec(
 			prometheus.GaugeOpts{
-				Namespace: namespace,
-				Name:      "http_requests_in_flight",
-				Help:      "Current number of HTTP requests being processed",
+				Namespace:   namespace,
+				ConstLabels: constLabels,
+				Name:        "http_response_size_max_bytes",
+				Help:        "Largest HTTP response size in bytes since the previous scrape",
 			},
-			[]string{"method"},
-		),
-		TotalErrors: promauto.NewCounterVec(
+			m.routeLabels,
+		)
+	}
+
+	if cfg.CountStatusByMethod {
+		m.RequestsByStatusMethod = prometheus.NewCounterVec(
 			prometheus.CounterOpts{
-				Namespace: namespace,
-				Name:      "http_errors_total",
-				Help:      "Total number of HTTP errors",
+				Namespace:   namespace,
+				ConstLabels: constLabels,
+				Name:        "http_requests_by_status_method",
+				Help:        "HTTP requests partitioned by method and status code",
+			},
+			[]string{"method", "status_class", "status_code"},
+		)
+	}
+
+	if cfg.DurationMilliseconds {
+		buckets := cfg.DurationMillisecondsBuckets
+		if len(buckets) == 0 {
+			buckets = []float64{5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000}
+		}
+		m.ResponseDurationMilliseconds = prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace:   namespace,
+				ConstLabels: constLabels,
+				Name:        "http_request_duration_milliseconds",
+				Help:        "HTTP request latency in milliseconds (prefer http_request_duration_seconds)",
+				Buckets:     buckets,
+			},
+			m.durationLabels,
+		)
+	}
+
+	if cfg.SizeKiB {
+		buckets := cfg.SizeKiBBuckets
+		if len(buckets) == 0 {
+			buckets = prometheus.ExponentialBuckets(1, 4, 10)
+		}
+		m.RequestSizeKiB = prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace:   namespace,
+				ConstLabels: constLabels,
+				Name:        "http_request_size_kibibytes",
+				Help:        "HTTP request size in KiB (prefer http_request_size_bytes)",
+				Buckets:     buckets,
+			},
+			m.sizeLabels,
+		)
+		m.ResponseSizeKiB = prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace:   namespace,
+				ConstLabels: constLabels,
+				Name:        "http_response_size_kibibytes",
+				Help:        "HTTP response size in KiB (prefer http_response_size_bytes)",
+				Buckets:     buckets,
+			},
+			m.sizeLabels,
+		)
+	}
+
+	if cfg.DurationSummary {
+		m.ResponseDurationSummary = prometheus.NewSummaryVec(
+			prometheus.SummaryOpts{
+				Namespace:   namespace,
+				ConstLabels: constLabels,
+				Name:        "http_request_duration_summary_seconds",
+				Help:        "HTTP request latency quantiles in seconds, for long-term storage",
+				Objectives:  map[float64]float64{0.5: 0.05, 0.95: 0.01, 0.99: 0.001},
+				MaxAge:      time.Hour,
+				AgeBuckets:  6,
 			},
-			[]string{"method", "path", "error_type"},
-		),
-		RequestsByStatus: promauto.NewCounterVec(
+			m.durationLabels,
+		)
+	}
+
+	if cfg.LargeResponseThreshold > 0 {
+		m.LargeResponses = prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace:   namespace,
+				ConstLabels: constLabels,
+				Name:        "http_large_responses_total",
+				Help:        "Total number of HTTP responses larger than the configured threshold",
+			},
+			m.routeLabels,
+		)
+	}
+
+	if cfg.MeasureOverhead {
+		m.MiddlewareOverhead = prometheus.NewHistogram(
+			prometheus.HistogramOpts{
+				Namespace:   namespace,
+				ConstLabels: constLabels,
+				Name:        "http_middleware_overhead_seconds",
+				Help:        "Time spent by the metrics middleware itself, excluding the handler",
+				Buckets:     prometheus.ExponentialBuckets(1e-7, 4, 10),
+			},
+		)
+	}
+
+	if cfg.TrackConnections {
+		m.Connections = prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace:   namespace,
+				ConstLabels: constLabels,
+				Name:        "http_connections",
+				Help:        "Current number of HTTP connections by state",
+			},
+			[]string{"state"},
+		)
+		m.ConnectionsTotal = prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Namespace:   namespace,
+				ConstLabels: constLabels,
+				Name:        "http_connections_total",
+				Help:        "Total number of accepted HTTP connections",
+			},
+		)
+	}
+
+	if cfg.CountEmptyResponses {
+		m.EmptyResponses = prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace:   namespace,
+				ConstLabels: constLabels,
+				Name:        "http_empty_responses_total",
+				Help:        "Total number of HTTP handlers that returned without writing a response",
+			},
+			m.emptyLabels,
+		)
+	}
+
+	if cfg.CountSuccesses {
+		m.Successes = prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace:   namespace,
+				ConstLabels: constLabels,
+				Name:        "http_success_total",
+				Help:        "Total number of successful HTTP responses",
+			},
+			m.routeLabels,
+		)
+	}
+
+	if cfg.Outcomes != nil {
+		m.Outcomes = prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace:   namespace,
+				ConstLabels: constLabels,
+				Name:        "http_request_outcome_total",
+				Help:        "Total number of request outcomes recorded by HTTP handlers",
+			},
+			cfg.labelNames("method", "path", "outcome"),
+		)
+	}
+
+	if cfg.TrackTLSHandshakeErrors {
+		m.TLSHandshakeErrors = prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace:   namespace,
+				ConstLabels: constLabels,
+				Name:        "tls_handshake_errors_total",
+				Help:        "Total number of failed TLS handshakes by reason",
+			},
+			[]string{"reason"},
+		)
+	}
+
+	if cfg.CountTimeouts {
+		m.RequestTimeouts = prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace:   namespace,
+				ConstLabels: constLabels,
+				Name:        "http_request_timeouts_total",
+				Help:        "Total number of HTTP requests timed out by TimeoutMiddleware",
+			},
+			m.routeLabels,
+		)
+	}
+
+	if cfg.CountBodyTooLarge {
+		m.BodyTooLarge = prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace:   namespace,
+				ConstLabels: constLabels,
+				Name:        "http_request_body_too_large_total",
+				Help:        "Total number of HTTP requests rejected by MaxBodyMiddleware for an oversized body",
+			},
+			m.routeLabels,
+		)
+	}
+
+	if cfg.TrackInFlightMax {
+		m.RequestsInFlightMax = NewMaxGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace:   namespace,
+				ConstLabels: constLabels,
+				Name:        "http_requests_in_flight_max",
+				Help:        "Peak number of concurrent HTTP requests per route since the previous scrape",
+			},
+			m.routeLabels,
+		)
+		m.RequestsInFlightMax.seed = m.routeInFlight.each
+	}
+
+	if cfg.TrackCompressionRatio {
+		m.CompressionRatio = prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace:   namespace,
+				ConstLabels: constLabels,
+				Name:        "http_response_compression_ratio",
+				Help:        "Ratio of uncompressed to sent size of encoded HTTP responses",
+				Buckets:     compressionRatioBuckets,
+			},
+			m.pathLabels,
+		)
+	}
+
+	if cfg.TrackBackendDuration {
+		m.BackendDuration = prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace:   namespace,
+				ConstLabels: constLabels,
+				Name:        "http_backend_duration_seconds",
+				Help:        "Time HTTP requests spent waiting on backends in seconds",
+				Buckets:     cfg.durationBuckets(),
+			},
+			m.routeLabels,
+		)
+	}
+
+	if len(cfg.SecurityHeaders) > 0 {
+		m.MissingSecurityHeaders = prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace:   namespace,
+				ConstLabels: constLabels,
+				Name:        "http_responses_missing_security_headers_total",
+				Help:        "Total number of HTTP responses sent without a configured security header",
+			},
+			[]string{"header"},
+		)
+	}
+
+	if cfg.TrackHeaderSize {
+		m.ResponseHeaderSize = prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace:   namespace,
+				ConstLabels: constLabels,
+				Name:        "http_response_header_size_bytes",
+				Help:        "Estimated HTTP response header size in bytes",
+				Buckets:     prometheus.ExponentialBuckets(64, 2, 10),
+			},
+			m.sizeLabels,
+		)
+	}
+
+	if cfg.TrackRateLimits {
+		m.RateLimited = prometheus.NewCounterVec(
 			prometheus.CounterOpts{
-				Namespace: namespace,
-				Name:      "http_requests_by_status",
-				Help:      "HTTP requests partitioned by status code",
+				Namespace:   namespace,
+				ConstLabels: constLabels,
+				Name:        "http_rate_limited_total",
+				Help:        "Total number of HTTP requests answered with 429 Too Many Requests",
+			},
+			m.routeLabels,
+		)
+		m.RetryAfter = prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace:   namespace,
+				ConstLabels: constLabels,
+				Name:        "http_retry_after_seconds",
+				Help:        "Retry-After delay sent with 429 responses in seconds",
+				Buckets:     retryAfterBuckets,
+			},
+			m.routeLabels,
+		)
+	}
+
+	if cfg.TrackCacheTTL {
+		m.ResponseCacheTTL = prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace:   namespace,
+				ConstLabels: constLabels,
+				Name:        "http_response_cache_ttl_seconds",
+				Help:        "Cache-Control max-age of HTTP responses in seconds",
+				Buckets:     cacheTTLBuckets,
 			},
-			[]string{"status_class", "status_code"},
-		),
+			m.routeLabels,
+		)
 	}
+
+	if cfg.AttemptCountHeader != "" {
+		m.UpstreamAttempts = prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace:   namespace,
+				ConstLabels: constLabels,
+				Name:        "http_upstream_attempt_count",
+				Help:        "Attempt count of HTTP requests as reported by the load balancer",
+				Buckets:     attemptCountBuckets,
+			},
+			m.pathLabels,
+		)
+	}
+
+	return m
 }
 
-// ResponseWriter wrapper that captures additional metrics
+// collectors returns all enabled metrics for registration
+func (m *Metrics) collectors() []prometheus.Collector {
+	if m.disabled {
+		return nil
+	}
+	cs := []prometheus.Collector{
+		m.RequestCounter,
+		m.ResponseDuration,
+		m.RequestSize,
+		m.ResponseSize,
+		m.RequestsInFlight,
+		m.TotalErrors,
+		m.RequestsByStatus,
+	}
+	if m.RequestIDGenerated != nil {
+		cs = append(cs, m.RequestIDGenerated)
+	}
+	if m.SlowRequestGoroutines != nil {
+		cs = append(cs, m.SlowRequestGoroutines, m.SlowRequestHeapAlloc)
+	}
+	if m.RequestQueueDuration != nil {
+		cs = append(cs, m.RequestQueueDuration, m.RequestsRejected, m.SaturationRatio)
+	}
+	if m.RequestBodyReadDuration != nil {
+		cs = append(cs, m.RequestBodyReadDuration)
+	}
+	if m.Redirects != nil {
+		cs = append(cs, m.Redirects)
+	}
+	if m.ResponseFlushes != nil {
+		cs = append(cs, m.ResponseFlushes, m.TimeToFirstByte)
+	}
+	if m.ResponseSizeMax != nil {
+		cs = append(cs, m.ResponseSizeMax)
+	}
+	if m.RequestsByStatusMethod != nil {
+		cs = append(cs, m.RequestsByStatusMethod)
+	}
+	if m.ResponseDurationMilliseconds != nil {
+		cs = append(cs, m.ResponseDurationMilliseconds)
+	}
+	if m.RequestSizeKiB != nil {
+		cs = append(cs, m.RequestSizeKiB, m.ResponseSizeKiB)
+	}
+	if m.ResponseDurationSummary != nil {
+		cs = append(cs, m.ResponseDurationSummary)
+	}
+	if m.LargeResponses != nil {
+		cs = append(cs, m.LargeResponses)
+	}
+	if m.MiddlewareOverhead != nil {
+		cs = append(cs, m.MiddlewareOverhead)
+	}
+	if m.Connections != nil {
+		cs = append(cs, m.Connections, m.ConnectionsTotal)
+	}
+	if m.EmptyResponses != nil {
+		cs = append(cs, m.EmptyResponses)
+	}
+	if m.Successes != nil {
+		cs = append(cs, m.Successes)
+	}
+	if m.UpstreamAttempts != nil {
+		cs = append(cs, m.UpstreamAttempts)
+	}
+	if m.Outcomes != nil {
+		cs = append(cs, m.Outcomes)
+	}
+	if m.TLSHandshakeErrors != nil {
+		cs = append(cs, m.TLSHandshakeErrors)
+	}
+	if m.RequestTimeouts != nil {
+		cs = append(cs, m.RequestTimeouts)
+	}
+	if m.BodyTooLarge != nil {
+		cs = append(cs, m.BodyTooLarge)
+	}
+	if m.RequestsInFlightMax != nil {
+		cs = append(cs, m.RequestsInFlightMax)
+	}
+	if m.CompressionRatio != nil {
+		cs = append(cs, m.CompressionRatio)
+	}
+	if m.BackendDuration != nil {
+		cs = append(cs, m.BackendDuration)
+	}
+	if m.MissingSecurityHeaders != nil {
+		cs = append(cs, m.MissingSecurityHeaders)
+	}
+	if m.ResponseHeaderSize != nil {
+		cs = append(cs, m.ResponseHeaderSize)
+	}
+	if m.RateLimited != nil {
+		cs = append(cs, m.RateLimited, m.RetryAfter)
+	}
+	if m.ResponseCacheTTL != nil {
+		cs = append(cs, m.ResponseCacheTTL)
+	}
+	return cs
+}
+
+// ResponseRecorder is a response writer that reports the status code and
+// body size of the response it wrapped. Middleware uses it to record the
+// response; see Config.WrapResponseWriter to supply your own.
+type ResponseRecorder interface {
+	http.ResponseWriter
+	// StatusCode returns the status sent, or 200 if none was set explicitly
+	StatusCode() int
+	// BytesWritten returns the number of body bytes written
+	BytesWritten() int64
+}
+
+// ResponseWriter wrapper that captures additional metrics. Handlers aren't
+// supposed to write from several goroutines, but some do, so the recorded
+// state is updated atomically to stay race-free.
 type metricsResponseWriter struct {
 	http.ResponseWriter
-	statusCode   int
-	responseSize int64
+	statusCode   atomic.Int64
+	responseSize atomic.Int64
+	wroteHeader  atomic.Bool
+
+	// streaming statistics, only tracked when trackStreaming is set
+	trackStreaming bool
+	now            func() time.Time
+	start          time.Time
+	firstByte      atomic.Int64 // nanoseconds since start, 0 until set
+	flushes        atomic.Int64
+
+	// estimated header size, only tracked when trackHeaderSize is set
+	trackHeaderSize bool
+	headerSize      atomic.Int64
+
+	// Retry-After header as sent, only tracked when trackRetryAfter is set
+	trackRetryAfter bool
+	retryAfter      atomic.Pointer[string]
+
+	// Cache-Control header as sent, only tracked when trackCacheTTL is set
+	trackCacheTTL bool
+	cacheControl  atomic.Pointer[string]
 }
 
 func newMetricsResponseWriter(w http.ResponseWriter) *metricsResponseWriter {
-	return &metricsResponseWriter{
-		ResponseWriter: w,
-		statusCode:     http.StatusOK,
-	}
+	mw := &metricsResponseWriter{ResponseWriter: w}
+	mw.statusCode.Store(http.StatusOK)
+	return mw
 }
 
+// WriteHeader records the status code. Calls after the headers have been
+// sent (by an earlier WriteHeader or Write) are ignored instead of being
+// forwarded, so they neither clobber the recorded status nor trigger the
+// "superfluous response.WriteHeader call" log.
 func (w *metricsResponseWriter) WriteHeader(statusCode int) {
-	w.statusCode = statusCode
+	if w.wroteHeader.Load() {
+		return
+	}
+	// Informational responses (e.g. 103 Early Hints) may precede the final
+	// header and are passed through untracked
+	if statusCode >= 100 && statusCode < 200 && statusCode != http.StatusSwitchingProtocols {
+		w.ResponseWriter.WriteHeader(statusCode)
+		return
+	}
+	if !w.wroteHeader.CompareAndSwap(false, true) {
+		return
+	}
+	w.statusCode.Store(int64(statusCode))
+	w.markHeaderSent()
 	w.ResponseWriter.WriteHeader(statusCode)
 }
 
+// Write counts the bytes actually written, which on a short write is the
+// returned count rather than len(b). Counts outside [0, len(b)] reported by
+// misbehaving writers are clamped so the recorded size stays truthful.
 func (w *metricsResponseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader.Swap(true) {
+		w.markHeaderSent()
+	}
+	w.markFirstByte()
 	size, err := w.ResponseWriter.Write(b)
-	w.responseSize += int64(size)
+	w.responseSize.Add(int64(min(max(size, 0), len(b))))
 	return size, err
 }
 
-// Middleware creates a new middleware handler with the provided metrics
+// StatusCode implements ResponseRecorder
+func (w *metricsResponseWriter) StatusCode() int {
+	return int(w.statusCode.Load())
+}
+
+// BytesWritten implements ResponseRecorder
+func (w *metricsResponseWriter) BytesWritten() int64 {
+	return w.responseSize.Load()
+}
+
+// Flush forwards to the underlying writer if it supports flushing.
+// Flushing sends the headers, so later WriteHeader calls are ignored.
+func (w *metricsResponseWriter) Flush() {
+	if !w.wroteHeader.Swap(true) {
+		w.markHeaderSent()
+	}
+	if w.trackStreaming {
+		w.flushes.Add(1)
+		w.markFirstByte()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// markHeaderSent estimates the size of the final header as it is sent
+func (w *metricsResponseWriter) markHeaderSent() {
+	if w.trackHeaderSize {
+		w.headerSize.Store(estimateHeaderSize(w.Header()))
+	}
+	if w.trackRetryAfter {
+		retryAfter := w.Header().Get("Retry-After")
+		w.retryAfter.Store(&retryAfter)
+	}
+	if w.trackCacheTTL {
+		cacheControl := strings.Join(w.Header().Values("Cache-Control"), ",")
+		w.cacheControl.Store(&cacheControl)
+	}
+}
+
+// markFirstByte records the time to first byte on the first write or flush
+func (w *metricsResponseWriter) markFirstByte() {
+	if w.trackStreaming && w.firstByte.Load() == 0 {
+		w.firstByte.CompareAndSwap(0, max(int64(since(w.now, w.start)), 1))
+	}
+}
+
+// timeToFirstByte returns the time from the start of the request until the
+// first write or flush
+func (w *metricsResponseWriter) timeToFirstByte() time.Duration {
+	return time.Duration(w.firstByte.Load())
+}
+
+// estimateHeaderSize sums the lengths of the header lines, "Key: value"
+// plus CRLF. Headers the server adds itself (Date, Content-Length, a
+// sniffed Content-Type) and the status line are not included.
+func estimateHeaderSize(h http.Header) int64 {
+	var size int64
+	for key, values := range h {
+		for _, value := range values {
+			size += int64(len(key) + len(": ") + len(value) + len("\r\n"))
+		}
+	}
+	return size
+}
+
+// hasTrailers reports whether the handler declared or set trailers
+func hasTrailers(h http.Header) bool {
+	if len(h.Values("Trailer")) > 0 {
+		return true
+	}
+	for key := range h {
+		if strings.HasPrefix(key, http.TrailerPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Unwrap returns the underlying writer for http.ResponseController
+func (w *metricsResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// SetEnabled switches the recording of Middleware on or off at runtime,
+// e.g. to shed the cost of metrics under extreme load. While disabled,
+// requests pass straight through, or with Config.CountWhenDisabled only
+// update the counters, skipping the duration and size histograms.
+func (m *Metrics) SetEnabled(enabled bool) {
+	m.paused.Store(!enabled)
+}
+
+// Middleware creates a new middleware handler with the provided metrics.
+//
+// If the handler panics, the request is still recorded, with its duration
+// and a 500 status, before the panic is propagated. This keeps the data
+// consistent whether RecoverMiddleware is placed inside or outside of
+// Middleware.
+//
+// The duration ends when the handler returns. Trailers declared by the
+// handler are sent by net/http afterwards, from the header map rather than
+// through Write, so they affect neither the recorded status nor the size,
+// and the time to send them is not part of the duration.
 func (m *Metrics) Middleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		start := time.Now()
+	return m.middleware(next, DefaultRouterLabel)
+}
 
-		// Track in-flight requests
-		m.RequestsInFlight.WithLabelValues(r.Method).Inc()
-		defer m.RequestsInFlight.WithLabelValues(r.Method).Dec()
+// MiddlewareForGroup returns a Middleware for the handlers of a router
+// group, e.g. the sub-router mounted at /api, labelling its requests with
+// router=name when Config.RouterLabel is set. Group names are fixed at
+// wiring time, which keeps the label bounded.
+func (m *Metrics) MiddlewareForGroup(name string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return m.middleware(next, name)
+	}
+}
 
-		// Track request size
-		if r.ContentLength > 0 {
-			m.RequestSize.WithLabelValues(r.Method, r.URL.Path).Observe(float64(r.ContentLength))
+func (m *Metrics) middleware(next http.Handler, router string) http.Handler {
+	if m.disabled {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(m.cfg.ExcludePaths) > 0 && slices.Contains(m.cfg.ExcludePaths, r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if m.paused.Load() && !m.cfg.CountWhenDisabled {
+			next.ServeHTTP(w, r)
+			return
 		}
 
-		// Wrap response writer to capture metrics
-		metricsWriter := newMetricsResponseWriter(w)
+		t := m.startRequest(w, r)
+		t.info.router = router
+		defer func() {
+			if err := recover(); err != nil {
+				m.finishRequest(t, http.StatusInternalServerError)
+				panic(err)
+			}
+		}()
 
 		// Call the next handler
-		next.ServeHTTP(metricsWriter, r)
+		t.markHandler(true)
+		next.ServeHTTP(t.recorder, t.request)
+		t.markHandler(false)
+
+		m.finishRequest(t, 0)
+	})
+}
+
+// trackedRequest carries the state of an instrumented request from
+// startRequest to finishRequest
+type trackedRequest struct {
+	start         time.Time
+	request       *http.Request
+	info          *requestInfo
+	body          *countingBody
+	recorder      ResponseRecorder
+	metricsWriter *metricsResponseWriter
+	probe         *slowRequestProbe
+	outcomes      *RequestRecorder
+	routeInFlight string // route key, only set with RequestsInFlightMax
+
+	// handler boundaries, only set with Config.MeasureOverhead
+	measureOverhead bool
+	handlerStart    time.Time
+	handlerEnd      time.Time
+
+	// now is the clock of the request, see Config.Now
+	now func() time.Time
+}
+
+// markHandler records when the handler starts or returns
+func (t *trackedRequest) markHandler(starting bool) {
+	if !t.measureOverhead {
+		return
+	}
+	if starting {
+		t.handlerStart = t.now()
+	} else {
+		t.handlerEnd = t.now()
+	}
+}
+
+// startRequest begins instrumenting a request. The handler must be called
+// with the returned recorder and request.
+func (m *Metrics) startRequest(w http.ResponseWriter, r *http.Request) *trackedRequest {
+	start := m.now()
+
+	// Share the request state with handlers through the context
+	state := &requestState{start: start, now: m.now, observers: &m.observers}
+	if m.Outcomes != nil {
+		state.recorder = m.newRequestRecorder()
+	}
+	r = r.WithContext(withRequestState(r.Context(), state))
+
+	t := &trackedRequest{
+		start:           start,
+		request:         r,
+		info:            &requestInfo{request: r, method: r.Method, router: DefaultRouterLabel, coldStart: isColdStart()},
+		outcomes:        state.recorder,
+		measureOverhead: m.MiddlewareOverhead != nil,
+		now:             m.now,
+	}
+
+	// Track in-flight requests
+	m.RequestsInFlight.WithLabelValues(r.Method).Inc()
+	m.inFlight.Add(1)
+	if m.RequestsInFlightMax != nil {
+		t.routeInFlight = m.startRouteInFlight(t.info)
+	}
 
-		// Record duration
-		duration := time.Since(start).Seconds()
-		statusCode := strconv.Itoa(metricsWriter.statusCode)
-		statusClass := strconv.Itoa(metricsWriter.statusCode/100) + "xx"
+	// Wrap request body to observe how it is read
+	t.body = m.wrapBody(r)
 
-		// Update metrics
-		m.RequestCounter.WithLabelValues(r.Method, r.URL.Path, statusCode).Inc()
-		m.ResponseDuration.WithLabelValues(r.Method, r.URL.Path, statusCode).Observe(duration)
-		m.RequestsByStatus.WithLabelValues(statusClass, statusCode).Inc()
+	// Wrap response writer to capture metrics
+	if m.cfg.WrapResponseWriter != nil {
+		t.recorder = m.cfg.WrapResponseWriter(w)
+	} else {
+		t.metricsWriter = newMetricsResponseWriter(w)
+		t.metricsWriter.trackStreaming = m.ResponseFlushes != nil
+		t.metricsWriter.trackHeaderSize = m.ResponseHeaderSize != nil
+		t.metricsWriter.trackRetryAfter = m.RateLimited != nil
+		t.metricsWriter.trackCacheTTL = m.ResponseCacheTTL != nil
+		t.metricsWriter.now = m.now
+		t.metricsWriter.start = start
+		t.recorder = t.metricsWriter
+	}
+
+	if m.SlowRequestGoroutines != nil {
+		t.probe = m.startSlowRequestProbe()
+	}
+
+	return t
+}
+
+// finishRequest records a request once its handler returned. A non-zero
+// statusOverride replaces the recorded status, e.g. with 500 after a panic.
+func (m *Metrics) finishRequest(t *trackedRequest, statusOverride int) {
+	r, info, recorder := t.request, t.info, t.recorder
+
+	m.RequestsInFlight.WithLabelValues(r.Method).Dec()
+	m.inFlight.Add(-1)
+	if m.RequestsInFlightMax != nil {
+		m.finishRouteInFlight(t.routeInFlight)
+	}
+
+	info.path = m.pathLabel(r)
+
+	if t.probe != nil {
+		m.finishSlowRequestProbe(t.probe, info)
+	}
+	if t.body != nil {
+		m.observeBody(t.body, info)
+	}
+	if m.UpstreamAttempts != nil {
+		m.observeAttemptCount(r, info)
+	}
+	if t.outcomes != nil {
+		m.observeOutcomes(t.outcomes, info)
+	}
+
+	// Record duration
+	duration := since(t.now, t.start).Seconds()
+	statusCode := recorder.StatusCode()
+	if statusOverride != 0 {
+		statusCode = statusOverride
+	}
+	responseSize := recorder.BytesWritten()
+
+	// With Config.DetailedErrorsOnly, successful requests only update
+	// the counters, and so do all requests while disabled by SetEnabled
+	// and those left out by Config.HistogramSampleRate
+	detailed := (!m.cfg.DetailedErrorsOnly || statusCode >= 400) && !m.paused.Load() && m.sampled()
 
-		// Track response size
-		if metricsWriter.responseSize > 0 {
-			m.ResponseSize.WithLabelValues(r.Method, r.URL.Path).Observe(float64(metricsWriter.responseSize))
+	// Update metrics
+	m.observeResponse(info, statusCode, recorder.Header(), duration, detailed)
+	if m.RateLimited != nil && statusCode == http.StatusTooManyRequests {
+		m.observeRateLimited(t, info)
+	}
+
+	// Track request size
+	if size, ok := m.requestSize(r, t.body); detailed && ok {
+		labels := m.labelValues(m.sizeLabels, info)
+		m.RequestSize.WithLabelValues(labels...).Observe(float64(size))
+		if m.RequestSizeKiB != nil {
+			m.RequestSizeKiB.WithLabelValues(labels...).Observe(float64(size) / 1024)
 		}
+	}
 
-		// Track errors (status code >= 400)
-		if metricsWriter.statusCode >= 400 {
-			errorType := "client_error"
-			if metricsWriter.statusCode >= 500 {
-				errorType = "server_error"
-			}
-			m.TotalErrors.WithLabelValues(r.Method, r.URL.Path, errorType).Inc()
+	// Track response size
+	if detailed && responseSize > 0 {
+		labels := m.labelValues(m.sizeLabels, info)
+		m.ResponseSize.WithLabelValues(labels...).Observe(float64(responseSize))
+		if m.ResponseSizeKiB != nil {
+			m.ResponseSizeKiB.WithLabelValues(labels...).Observe(float64(responseSize) / 1024)
 		}
-	})
+	}
+	if m.ResponseSizeMax != nil {
+		m.ResponseSizeMax.Observe(float64(responseSize), m.labelValues(m.routeLabels, info)...)
+	}
+	if m.LargeResponses != nil && responseSize > m.cfg.LargeResponseThreshold {
+		m.LargeResponses.WithLabelValues(m.labelValues(m.routeLabels, info)...).Inc()
+	}
+	if m.CompressionRatio != nil {
+		m.observeCompressionRatio(t, info, responseSize)
+	}
+	if m.BackendDuration != nil {
+		m.observeBackendLatency(t, info)
+	}
+	if m.ResponseCacheTTL != nil {
+		m.observeCacheTTL(t, info)
+	}
+	if mw := t.metricsWriter; m.ResponseHeaderSize != nil && mw != nil {
+		size := mw.headerSize.Load()
+		if !mw.wroteHeader.Load() {
+			// The server sends the header after the handler returned
+			size = estimateHeaderSize(mw.Header())
+		}
+		m.ResponseHeaderSize.WithLabelValues(m.labelValues(m.sizeLabels, info)...).Observe(float64(size))
+	}
+
+	// Track streaming responses
+	if mw := t.metricsWriter; mw != nil && mw.flushes.Load() > 0 {
+		labels := m.labelValues(m.pathLabels, info)
+		m.ResponseFlushes.WithLabelValues(labels...).Add(float64(mw.flushes.Load()))
+		m.TimeToFirstByte.WithLabelValues(labels...).Observe(mw.timeToFirstByte().Seconds())
+	}
+
+	// Track handlers that wrote nothing at all, which a 0 byte response
+	// size can't tell apart from a legitimate 204. Trailers-only responses
+	// (e.g. gRPC errors) do carry a response.
+	if mw := t.metricsWriter; m.EmptyResponses != nil && mw != nil && statusOverride == 0 &&
+		!mw.wroteHeader.Load() && !hasTrailers(mw.Header()) {
+		m.EmptyResponses.WithLabelValues(m.labelValues(m.emptyLabels, info)...).Inc()
+	}
+
+	// Track our own bookkeeping time around the handler
+	if t.measureOverhead && !t.handlerEnd.IsZero() {
+		overhead := max(t.handlerStart.Sub(t.start), 0) + since(t.now, t.handlerEnd)
+		m.MiddlewareOverhead.Observe(overhead.Seconds())
+	}
+}
+
+// observeResponse records a finished request in the metrics shared by
+// Middleware and manual recording: the request counter, the duration
+// histogram if detailed is set, and the status, redirect, error and
+// success counters. header is the response header, nil if unknown.
+func (m *Metrics) observeResponse(info *requestInfo, statusCode int, header http.Header, duration float64, detailed bool) {
+	info.status = strconv.Itoa(statusCode)
+	info.statusClass = strconv.Itoa(statusCode/100) + "xx"
+	if m.cfg.FormatLabel && header != nil {
+		info.contentType = header.Get("Content-Type")
+	}
+
+	m.observeRequest(info.request, info, duration, detailed)
+	m.RequestsByStatus.WithLabelValues(info.statusClass, info.status).Inc()
+	if m.RequestsByStatusMethod != nil {
+		m.RequestsByStatusMethod.WithLabelValues(info.method, info.statusClass, info.status).Inc()
+	}
+
+	// Track redirects
+	if m.Redirects != nil && isRedirect(statusCode) {
+		m.Redirects.WithLabelValues(info.status).Inc()
+	}
+
+	// Track errors (status code >= 400)
+	if errorType, ok := m.errorType(statusCode); ok {
+		info.errorType = errorType
+		m.TotalErrors.WithLabelValues(m.labelValues(m.errorLabels, info)...).Inc()
+	}
+
+	// Track successes
+	if m.Successes != nil && m.isSuccess(statusCode) {
+		m.Successes.WithLabelValues(m.labelValues(m.routeLabels, info)...).Inc()
+	}
+}
+
+// isRedirect reports whether the status code redirects the client
+func isRedirect(statusCode int) bool {
+	switch statusCode {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
+
+// observeRequest updates the request counter and, if observeDuration is
+// set, the duration histogram, attaching exemplars when
+// Config.ExemplarFromContext is set
+func (m *Metrics) observeRequest(r *http.Request, info *requestInfo, duration float64, observeDuration bool) {
+	counter := m.RequestCounter.WithLabelValues(m.labelValues(m.requestLabels, info)...)
+
+	var observer prometheus.Observer
+	if observeDuration {
+		labels := m.labelValues(m.durationLabels, info)
+		observer = m.ResponseDuration.WithLabelValues(labels...)
+		if m.ResponseDurationMilliseconds != nil {
+			m.ResponseDurationMilliseconds.WithLabelValues(labels...).Observe(duration * 1000)
+		}
+		if m.ResponseDurationSummary != nil {
+			m.ResponseDurationSummary.WithLabelValues(labels...).Observe(duration)
+		}
+	}
+
+	var exemplar prometheus.Labels
+	if m.cfg.ExemplarFromContext != nil {
+		exemplar = m.cfg.ExemplarFromContext(r.Context())
+	}
+	if len(exemplar) == 0 {
+		counter.Inc()
+		if observer != nil {
+			observer.Observe(duration)
+		}
+		return
+	}
+
+	counter.(prometheus.ExemplarAdder).AddWithExemplar(1, exemplar)
+	if observer != nil {
+		observer.(prometheus.ExemplarObserver).ObserveWithExemplar(duration, exemplar)
+	}
 }
 
 // RecoverMiddleware adds panic recovery and metrics
 func (m *Metrics) RecoverMiddleware(next http.Handler) http.Handler {
+	if m.disabled {
+		return next
+	}
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		defer func() {
 			if err := recover(); err != nil {
-				m.TotalErrors.WithLabelValues(r.Method, r.URL.Path, "panic").Inc()
-				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				m.countPanic(r)
+				m.writeRecoverResponse(w)
 			}
 		}()
 		next.ServeHTTP(w, r)
 	})
 }
+
+// InstrumentWithRecovery combines Middleware and RecoverMiddleware in a
+// single pass, so there is no ordering to get wrong: it measures the
+// request, recovers from panics, counts them as "panic" errors, records
+// the recovery status (500 by default) in the request metrics, and writes
+// the configured recovery response.
+//
+// http.ErrAbortHandler panics are recorded but propagated, so the server
+// aborts the response as intended.
+func (m *Metrics) InstrumentWithRecovery(next http.Handler) http.Handler {
+	if m.disabled {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(m.cfg.ExcludePaths) > 0 && slices.Contains(m.cfg.ExcludePaths, r.URL.Path) {
+			m.RecoverMiddleware(next).ServeHTTP(w, r)
+			return
+		}
+
+		t := m.startRequest(w, r)
+		defer func() {
+			err := recover()
+			if err == nil {
+				return
+			}
+			if err == http.ErrAbortHandler {
+				m.finishRequest(t, http.StatusInternalServerError)
+				panic(err)
+			}
+
+			m.countPanic(t.request)
+			m.writeRecoverResponse(t.recorder)
+			m.finishRequest(t, m.recoverStatusCode())
+		}()
+
+		t.markHandler(true)
+		next.ServeHTTP(t.recorder, t.request)
+		t.markHandler(false)
+
+		m.finishRequest(t, 0)
+	})
+}
+
+// countPanic increments TotalErrors with the "panic" error type
+func (m *Metrics) countPanic(r *http.Request) {
+	info := &requestInfo{request: r, method: r.Method, path: m.pathLabel(r), errorType: "panic"}
+	m.TotalErrors.WithLabelValues(m.labelValues(m.errorLabels, info)...).Inc()
+}
+
+// recoverStatusCode returns the status sent after a panic
+func (m *Metrics) recoverStatusCode() int {
+	if m.cfg.RecoverStatusCode != 0 {
+		return m.cfg.RecoverStatusCode
+	}
+	return http.StatusInternalServerError
+}
+
+// writeRecoverResponse writes the response sent after a panic, a plain
+// text 500 unless configured otherwise
+func (m *Metrics) writeRecoverResponse(w http.ResponseWriter) {
+	statusCode := m.recoverStatusCode()
+
+	if m.cfg.RecoverResponseBody == nil {
+		http.Error(w, http.StatusText(statusCode), statusCode)
+		return
+	}
+
+	contentType := m.cfg.RecoverContentType
+	if contentType == "" {
+		contentType = http.DetectContentType(m.cfg.RecoverResponseBody)
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(statusCode)
+	_, _ = w.Write(m.cfg.RecoverResponseBody)
+}
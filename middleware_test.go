@@ -0,0 +1,205 @@
+package prommonitoring
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// shortWriter accepts at most limit bytes per Write and fails the rest
+type shortWriter struct {
+	http.ResponseWriter
+	limit int
+}
+
+var errShortWrite = errors.New("connection reset")
+
+func (w *shortWriter) Write(b []byte) (int, error) {
+	if len(b) <= w.limit {
+		return w.ResponseWriter.Write(b)
+	}
+	n, _ := w.ResponseWriter.Write(b[:w.limit])
+	return n, errShortWrite
+}
+
+func TestResponseSizeCountsShortWrites(t *testing.T) {
+	m, reg := newTestMetrics(t, DefaultConfig())
+
+	var written []int
+	handler := m.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, err := w.Write([]byte("abc"))
+		if err != nil {
+			t.Errorf("full write: %v", err)
+		}
+		written = append(written, n)
+		n, err = w.Write([]byte("defghij"))
+		if !errors.Is(err, errShortWrite) {
+			t.Errorf("short write error = %v, want %v", err, errShortWrite)
+		}
+		written = append(written, n)
+	}))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(&shortWriter{ResponseWriter: rec, limit: 4}, httptest.NewRequest(http.MethodGet, "/x", nil))
+
+	if want := []int{3, 4}; len(written) != 2 || written[0] != want[0] || written[1] != want[1] {
+		t.Fatalf("Write returned %v, want %v", written, want)
+	}
+	sum, count := histogramSum(t, reg, "app_http_response_size_bytes")
+	if count != 1 || sum != float64(rec.Body.Len()) || sum != 7 {
+		t.Errorf("response size = %v over %d responses, want 7 (bytes written: %d) over 1", sum, count, rec.Body.Len())
+	}
+}
+
+func TestMiddlewareRecordsPanics(t *testing.T) {
+	panicking := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})
+
+	for name, wrap := range map[string]func(m *Metrics, h http.Handler) http.Handler{
+		"recover outside": func(m *Metrics, h http.Handler) http.Handler {
+			return m.RecoverMiddleware(m.Middleware(h))
+		},
+		"recover inside": func(m *Metrics, h http.Handler) http.Handler {
+			return m.Middleware(m.RecoverMiddleware(h))
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			m, reg := newTestMetrics(t, DefaultConfig())
+
+			rec := httptest.NewRecorder()
+			wrap(m, panicking).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/x", nil))
+
+			if rec.Code != http.StatusInternalServerError {
+				t.Errorf("response status = %d, want 500", rec.Code)
+			}
+			if got := counterValue(t, reg, "app_http_requests_total", map[string]string{"status": "500"}); got != 1 {
+				t.Errorf("requests with status 500 = %v, want 1", got)
+			}
+			if _, count := histogramSum(t, reg, "app_http_request_duration_seconds"); count != 1 {
+				t.Errorf("duration observations = %d, want 1", count)
+			}
+		})
+	}
+}
+
+func TestMiddlewareRepanics(t *testing.T) {
+	m, reg := newTestMetrics(t, DefaultConfig())
+	handler := m.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}))
+
+	func() {
+		defer func() {
+			if err := recover(); err != "boom" {
+				t.Errorf("recovered %v, want the handler's panic", err)
+			}
+		}()
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/x", nil))
+		t.Error("Middleware swallowed the panic")
+	}()
+
+	if got := counterValue(t, reg, "app_http_requests_total", map[string]string{"status": "500"}); got != 1 {
+		t.Errorf("requests with status 500 = %v, want 1", got)
+	}
+	if _, count := histogramSum(t, reg, "app_http_request_duration_seconds"); count != 1 {
+		t.Errorf("duration observations = %d, want 1", count)
+	}
+}
+
+// concurrentWriter is a ResponseWriter that tolerates concurrent calls,
+// unlike httptest.ResponseRecorder
+type concurrentWriter struct {
+	header  http.Header
+	written atomic.Int64
+}
+
+func (w *concurrentWriter) Header() http.Header { return w.header }
+func (w *concurrentWriter) WriteHeader(int)     {}
+func (w *concurrentWriter) Flush()              {}
+
+func (w *concurrentWriter) Write(b []byte) (int, error) {
+	w.written.Add(int64(len(b)))
+	return len(b), nil
+}
+
+func TestResponseWriterConcurrentWrites(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.TrackStreaming = true
+	m, reg := newTestMetrics(t, cfg)
+
+	const writers, writes = 8, 100
+	handler := m.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var wg sync.WaitGroup
+		for i := range writers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for range writes {
+					w.WriteHeader(http.StatusAccepted + i%2)
+					_, _ = w.Write([]byte("abc"))
+					w.(http.Flusher).Flush()
+				}
+			}()
+		}
+		wg.Wait()
+	}))
+	w := &concurrentWriter{header: http.Header{}}
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/x", nil))
+
+	want := float64(writers * writes * 3)
+	if got := w.written.Load(); float64(got) != want {
+		t.Fatalf("underlying writer got %d bytes, want %v", got, want)
+	}
+	if sum, count := histogramSum(t, reg, "app_http_response_size_bytes"); count != 1 || sum != want {
+		t.Errorf("response size = %v over %d responses, want %v over 1", sum, count, want)
+	}
+}
+
+func TestMiddlewareWithTrailers(t *testing.T) {
+	for name, handler := range map[string]http.HandlerFunc{
+		"declared": func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Trailer", "X-Checksum")
+			_, _ = w.Write([]byte("hello"))
+			w.Header().Set("X-Checksum", "abc")
+		},
+		"prefixed": func(w http.ResponseWriter, r *http.Request) {
+			// Undeclared trailers need a chunked response
+			_, _ = w.Write([]byte("hello"))
+			w.(http.Flusher).Flush()
+			w.Header().Set(http.TrailerPrefix+"X-Checksum", "abc")
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			m, reg := newTestMetrics(t, DefaultConfig())
+			server := httptest.NewServer(m.Middleware(handler))
+			defer server.Close()
+
+			resp, err := http.Get(server.URL + "/x")
+			if err != nil {
+				t.Fatalf("GET: %v", err)
+			}
+			body, err := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err != nil {
+				t.Fatalf("reading body: %v", err)
+			}
+			if string(body) != "hello" {
+				t.Errorf("body = %q, want hello", body)
+			}
+			if got := resp.Trailer.Get("X-Checksum"); got != "abc" {
+				t.Errorf("trailer X-Checksum = %q, want abc", got)
+			}
+
+			if got := counterValue(t, reg, "app_http_requests_total", map[string]string{"status": "200"}); got != 1 {
+				t.Errorf("requests with status 200 = %v, want 1", got)
+			}
+			if sum, count := histogramSum(t, reg, "app_http_response_size_bytes"); count != 1 || sum != 5 {
+				t.Errorf("response size = %v over %d responses, want 5 over 1", sum, count)
+			}
+		})
+	}
+}
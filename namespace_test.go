@@ -0,0 +1,35 @@
+package prommonitoring
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestEmptyNamespaceExposesBareNames(t *testing.T) {
+	want := []string{
+		"http_errors_total",
+		"http_request_duration_seconds",
+		"http_requests_by_status",
+		"http_requests_in_flight",
+		"http_requests_total",
+		"http_response_size_bytes",
+	}
+
+	for name, newMetrics := range map[string]func() *Metrics{
+		"NewMetrics":           func() *Metrics { return NewMetrics("") },
+		"NewMetricsWithConfig": func() *Metrics { return NewMetricsWithConfig(&Config{Namespace: ""}) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			m := newMetrics()
+			reg := prometheus.NewRegistry()
+			reg.MustRegister(m)
+			serveOne(m)
+
+			if got := familyNames(t, reg); !slices.Equal(got, want) {
+				t.Errorf("exposed names = %v, want %v", got, want)
+			}
+		})
+	}
+}
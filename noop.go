@@ -0,0 +1,12 @@
+package prommonitoring
+
+// NoopMetrics returns a Metrics that records nothing. Its middlewares pass
+// requests straight through and it holds no collectors, so it can be used
+// in tests or when metrics are disabled without conditionals at the call
+// sites. Its metric fields are nil.
+func NoopMetrics() *Metrics {
+	return &Metrics{
+		cfg:      &Config{},
+		disabled: true,
+	}
+}
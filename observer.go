@@ -0,0 +1,93 @@
+package prommonitoring
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// requestObservers holds the histograms added with RegisterRequestObserver
+type requestObservers struct {
+	mu   sync.RWMutex
+	vecs map[string]*prometheus.HistogramVec
+}
+
+func (o *requestObservers) get(name string) *prometheus.HistogramVec {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	return o.vecs[name]
+}
+
+// RegisterRequestObserver adds a histogram for a domain value such as the
+// number of items per request, recorded by handlers with
+// ObserveRequestValue. It gets the namespace and constant labels of the
+// HTTP metrics and is registered on the registerer the Metrics were
+// registered on by InitMetrics or NewMetricsWithRegisterer; it is left
+// unregistered for Metrics that weren't, such as NoopMetrics. Registering
+// a name again returns the existing histogram; a collision with another
+// collector panics with a *RegistrationError.
+func (m *Metrics) RegisterRequestObserver(name string, labels []string, buckets []float64) *prometheus.HistogramVec {
+	o := &m.observers
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if vec, ok := o.vecs[name]; ok {
+		return vec
+	}
+
+	vec := prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace:   m.cfg.Namespace,
+			ConstLabels: m.cfg.constLabels(),
+			Name:        name,
+			Help:        "Values observed by request handlers for " + name,
+			Buckets:     buckets,
+		},
+		labels,
+	)
+	if m.registerer != nil {
+		vec = m.registerObserver(name, vec)
+	}
+
+	if o.vecs == nil {
+		o.vecs = make(map[string]*prometheus.HistogramVec)
+	}
+	o.vecs[name] = vec
+	return vec
+}
+
+// registerObserver registers vec, returning an identical histogram
+// registered before, e.g. by the Metrics replaced with Reconfigure, in its
+// place
+func (m *Metrics) registerObserver(name string, vec *prometheus.HistogramVec) *prometheus.HistogramVec {
+	err := m.registerer.Register(vec)
+	if err == nil {
+		return vec
+	}
+	var already prometheus.AlreadyRegisteredError
+	if errors.As(err, &already) {
+		if existing, ok := already.ExistingCollector.(*prometheus.HistogramVec); ok {
+			return existing
+		}
+	}
+	panic(&RegistrationError{Metric: prometheus.BuildFQName(m.cfg.Namespace, "", name), Err: err})
+}
+
+// ObserveRequestValue records value in the histogram registered as name
+// with RegisterRequestObserver, with the given label values. It does
+// nothing outside Middleware, for unknown names, or if the number of
+// label values doesn't match.
+func ObserveRequestValue(ctx context.Context, name string, value float64, labelValues ...string) {
+	state := requestStateFromContext(ctx)
+	if state == nil || state.observers == nil {
+		return
+	}
+	vec := state.observers.get(name)
+	if vec == nil {
+		return
+	}
+	if obs, err := vec.GetMetricWithLabelValues(labelValues...); err == nil {
+		obs.Observe(value)
+	}
+}
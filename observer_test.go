@@ -0,0 +1,48 @@
+package prommonitoring
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestRegisterRequestObserverUsesMetricsRegisterer(t *testing.T) {
+	cfg := &Config{Namespace: "obs"}
+	m, reg := newTestMetrics(t, cfg)
+
+	m.RegisterRequestObserver("items_per_request", []string{"kind"}, []float64{1, 5, 10})
+	if again := m.RegisterRequestObserver("items_per_request", []string{"kind"}, nil); again == nil {
+		t.Fatal("registering a name again returned nil")
+	}
+
+	handler := m.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ObserveRequestValue(r.Context(), "items_per_request", 3, "order")
+		ObserveRequestValue(r.Context(), "items_per_request", 3) // wrong label count
+		ObserveRequestValue(r.Context(), "unknown", 1, "order")  // not registered
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	metric := findMetric(t, reg, "obs_items_per_request", map[string]string{"kind": "order"})
+	if metric == nil {
+		t.Fatal("observer not found on the registerer of the metrics")
+	}
+	if got := metric.GetHistogram().GetSampleCount(); got != 1 {
+		t.Errorf("sample count = %d, want 1", got)
+	}
+	if cfg.Registry != nil {
+		t.Error("cfg.Registry was assigned although the metrics live on reg")
+	}
+}
+
+func TestRegisterRequestObserverOnNoopMetrics(t *testing.T) {
+	m := NoopMetrics()
+	vec := m.RegisterRequestObserver("noop_items", nil, nil)
+
+	// Still unregistered, so registering it elsewhere succeeds
+	if err := prometheus.DefaultRegisterer.Register(vec); err != nil {
+		t.Fatalf("observer of NoopMetrics was registered: %v", err)
+	}
+	prometheus.DefaultRegisterer.Unregister(vec)
+}
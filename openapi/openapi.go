@@ -0,0 +1,185 @@
+// Package openapi derives path labels from the path templates of an
+// OpenAPI document, so the path label matches the spec exactly:
+//
+//	mapper, err := openapi.RouteMapperFromOpenAPI(spec)
+//	cfg.PathPipeline = []prommonitoring.PathTransform{mapper.Map}
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	prommonitoring "github.com/cqwens/PromMonitoring"
+)
+
+// RouteMapper maps request paths to the path template they match. The
+// templates are compiled once into a segment tree, so matching a path
+// costs one lookup per segment. It is safe for concurrent use.
+type RouteMapper struct {
+	root *node
+}
+
+// node is a path segment of the tree. Literal segments are tried before
+// templated ones, as OpenAPI requires concrete paths to win over
+// templated ones.
+type node struct {
+	literals map[string]*node
+	params   []*paramNode
+	template string
+}
+
+// paramNode is a templated segment: {name} matches any segment, other
+// forms such as {name}.json are matched by a regular expression
+type paramNode struct {
+	pattern *regexp.Regexp
+	next    *node
+}
+
+// RouteMapperFromOpenAPI builds a RouteMapper from the "paths" of an
+// OpenAPI 3 or Swagger 2 document in JSON form. YAML documents must be
+// converted first.
+func RouteMapperFromOpenAPI(spec []byte) (*RouteMapper, error) {
+	var doc struct {
+		Paths map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(spec, &doc); err != nil {
+		return nil, fmt.Errorf("openapi: parsing spec: %w", err)
+	}
+
+	templates := make([]string, 0, len(doc.Paths))
+	for template := range doc.Paths {
+		// Specification extensions may sit next to the paths
+		if strings.HasPrefix(template, "x-") {
+			continue
+		}
+		templates = append(templates, template)
+	}
+	if len(templates) == 0 {
+		return nil, fmt.Errorf("openapi: spec has no paths")
+	}
+	return NewRouteMapper(templates)
+}
+
+// NewRouteMapper builds a RouteMapper from path templates such as
+// /pets/{petId}
+func NewRouteMapper(templates []string) (*RouteMapper, error) {
+	m := &RouteMapper{root: newNode()}
+	for _, template := range templates {
+		if err := m.add(template); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// Map returns the template matching path, or
+// prommonitoring.UnmatchedPathLabel. It can be used as a
+// prommonitoring.PathTransform.
+func (m *RouteMapper) Map(path string) string {
+	if template := m.root.match(splitPath(path)); template != "" {
+		return template
+	}
+	return prommonitoring.UnmatchedPathLabel
+}
+
+func (m *RouteMapper) add(template string) error {
+	if !strings.HasPrefix(template, "/") {
+		return fmt.Errorf("openapi: path template %q must start with /", template)
+	}
+
+	n := m.root
+	for _, segment := range splitPath(template) {
+		if !strings.Contains(segment, "{") {
+			next, ok := n.literals[segment]
+			if !ok {
+				next = newNode()
+				n.literals[segment] = next
+			}
+			n = next
+			continue
+		}
+
+		pattern, err := compileSegment(segment)
+		if err != nil {
+			return fmt.Errorf("openapi: path template %q: %w", template, err)
+		}
+		next := n.param(pattern)
+		if next == nil {
+			next = newNode()
+			n.params = append(n.params, &paramNode{pattern: pattern, next: next})
+		}
+		n = next
+	}
+	n.template = template
+	return nil
+}
+
+func newNode() *node {
+	return &node{literals: make(map[string]*node)}
+}
+
+// param returns the child of an equivalent templated segment, if any
+func (n *node) param(pattern *regexp.Regexp) *node {
+	for _, p := range n.params {
+		if p.pattern.String() == pattern.String() {
+			return p.next
+		}
+	}
+	return nil
+}
+
+// match returns the template of the remaining segments, backtracking to
+// templated segments when a literal branch doesn't lead to a template
+func (n *node) match(segments []string) string {
+	if len(segments) == 0 {
+		return n.template
+	}
+	segment, rest := segments[0], segments[1:]
+	if next, ok := n.literals[segment]; ok {
+		if template := next.match(rest); template != "" {
+			return template
+		}
+	}
+	for _, p := range n.params {
+		if p.pattern.MatchString(segment) {
+			if template := p.next.match(rest); template != "" {
+				return template
+			}
+		}
+	}
+	return ""
+}
+
+// compileSegment turns a templated segment into an anchored expression,
+// each {param} matching a non-empty run of characters
+func compileSegment(segment string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString("^")
+	for segment != "" {
+		open := strings.IndexByte(segment, '{')
+		if open < 0 {
+			b.WriteString(regexp.QuoteMeta(segment))
+			break
+		}
+		end := strings.IndexByte(segment[open:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated parameter in segment %q", segment)
+		}
+		b.WriteString(regexp.QuoteMeta(segment[:open]))
+		b.WriteString(".+?")
+		segment = segment[open+end+1:]
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
+
+// splitPath splits a path into its segments, ignoring a trailing slash
+func splitPath(path string) []string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, "/")
+}
@@ -0,0 +1,43 @@
+package openapi
+
+import (
+	"testing"
+
+	prommonitoring "github.com/cqwens/PromMonitoring"
+)
+
+func TestRouteMapperFromOpenAPI(t *testing.T) {
+	spec := []byte(`{
+		"openapi": "3.0.0",
+		"paths": {
+			"x-internal": {"owner": "team-a"},
+			"/pets": {},
+			"/pets/{petId}": {},
+			"/pets/mine": {},
+			"/files/{name}.json": {}
+		}
+	}`)
+	mapper, err := RouteMapperFromOpenAPI(spec)
+	if err != nil {
+		t.Fatalf("RouteMapperFromOpenAPI: %v", err)
+	}
+
+	for path, want := range map[string]string{
+		"/pets":              "/pets",
+		"/pets/42":           "/pets/{petId}",
+		"/pets/mine":         "/pets/mine",
+		"/files/report.json": "/files/{name}.json",
+		"/files/report.xml":  prommonitoring.UnmatchedPathLabel,
+		"/x-internal":        prommonitoring.UnmatchedPathLabel,
+	} {
+		if got := mapper.Map(path); got != want {
+			t.Errorf("Map(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestRouteMapperFromOpenAPIOnlyExtensions(t *testing.T) {
+	if _, err := RouteMapperFromOpenAPI([]byte(`{"paths": {"x-note": "none yet"}}`)); err == nil {
+		t.Error("expected an error for a spec without paths")
+	}
+}
@@ -0,0 +1,58 @@
+package prommonitoring
+
+import (
+	"context"
+	"slices"
+	"sync"
+)
+
+// OtherOutcome is the outcome label of outcomes not in Config.Outcomes
+const OtherOutcome = "other"
+
+// RequestRecorder lets a handler annotate its request with domain
+// outcomes such as "cache_hit" or "fallback_used". Middleware counts them
+// in http_request_outcome_total with the request's own method and path
+// once the handler returned. A nil RequestRecorder records nothing.
+type RequestRecorder struct {
+	allowed []string
+
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+// RequestRecorderFrom returns the recorder of the request, or nil outside
+// Middleware or when Config.Outcomes is unset
+func RequestRecorderFrom(ctx context.Context) *RequestRecorder {
+	if state := requestStateFromContext(ctx); state != nil {
+		return state.recorder
+	}
+	return nil
+}
+
+// IncOutcome counts an outcome for the request. Outcomes not listed in
+// Config.Outcomes are counted as OtherOutcome, keeping the label bounded.
+func (rr *RequestRecorder) IncOutcome(name string) {
+	if rr == nil {
+		return
+	}
+	if !slices.Contains(rr.allowed, name) {
+		name = OtherOutcome
+	}
+	rr.mu.Lock()
+	rr.counts[name]++
+	rr.mu.Unlock()
+}
+
+func (m *Metrics) newRequestRecorder() *RequestRecorder {
+	return &RequestRecorder{allowed: m.cfg.Outcomes, counts: make(map[string]int)}
+}
+
+// observeOutcomes adds the outcomes recorded by the handler
+func (m *Metrics) observeOutcomes(rr *RequestRecorder, info *requestInfo) {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	for outcome, n := range rr.counts {
+		labels := append(m.labelValues(m.routeLabels, info), outcome)
+		m.Outcomes.WithLabelValues(labels...).Add(float64(n))
+	}
+}
@@ -0,0 +1,135 @@
+package prommonitoring
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// DefaultUnknownPathLabel is used for requests without a usable path
+const DefaultUnknownPathLabel = "unknown"
+
+// DefaultConnectPathLabel is the path label of CONNECT requests
+const DefaultConnectPathLabel = "<connect>"
+
+// DefaultAsteriskPathLabel is the path label of "OPTIONS *" requests
+const DefaultAsteriskPathLabel = "*"
+
+// PathLabelMode selects how the request path is turned into the path label
+type PathLabelMode string
+
+const (
+	// PathLabelFull uses the full request path
+	PathLabelFull PathLabelMode = "full"
+	// PathLabelFirstSegment keeps only the first path segment, so
+	// /api/v1/users/123 is labelled /api
+	PathLabelFirstSegment PathLabelMode = "first-segment"
+	// PathLabelDepth uses the number of path segments, so
+	// /api/v1/users/123 is labelled 4. Depths from maxPathDepth on are
+	// folded into a single "10+" value.
+	PathLabelDepth PathLabelMode = "depth"
+	// PathLabelPattern uses the http.ServeMux pattern that matched the
+	// request (e.g. /users/{id}), without its method. Requests that matched
+	// no pattern are labelled UnmatchedPathLabel, so unknown URLs can't
+	// create new series. Middleware must wrap the mux itself.
+	PathLabelPattern PathLabelMode = "pattern"
+	// PathLabelOperation uses the operation ID the handler set with
+	// WithOperationID, falling back to the full path for requests
+	// without one
+	PathLabelOperation PathLabelMode = "operation"
+)
+
+// UnmatchedPathLabel is the path label of requests that matched no route
+const UnmatchedPathLabel = "<unmatched>"
+
+// maxPathDepth caps the values of the depth path label
+const maxPathDepth = 10
+
+// pathLabel returns the value of the path label for the request. It is
+// resolved after the handler ran, so routers can annotate the request.
+// Requests without a path get fixed labels so they don't produce empty or
+// host-bearing series: CONNECT requests, whose target is a host:port, are
+// mapped to Config.ConnectPathLabel, "OPTIONS *" to
+// Config.AsteriskPathLabel and empty paths to Config.UnknownPathLabel.
+func (m *Metrics) pathLabel(r *http.Request) string {
+	path := r.URL.Path
+	switch {
+	case r.Method == http.MethodConnect:
+		return labelOrDefault(m.cfg.ConnectPathLabel, DefaultConnectPathLabel)
+	case r.Method == http.MethodOptions && (path == "*" || r.RequestURI == "*"):
+		return labelOrDefault(m.cfg.AsteriskPathLabel, DefaultAsteriskPathLabel)
+	case path == "":
+		return m.unknownPathLabel()
+	}
+
+	switch m.cfg.PathLabelMode {
+	case PathLabelPattern:
+		path = patternPath(r.Pattern)
+	case PathLabelFirstSegment:
+		path = firstSegment(path)
+	case PathLabelDepth:
+		return pathDepth(path)
+	case PathLabelOperation:
+		if id := operationIDFromContext(r.Context()); id != "" {
+			return m.sanitizeLabel(id)
+		}
+	}
+	if m.cfg.NormalizeTrailingSlash {
+		path = trimTrailingSlash(path)
+	}
+	return m.sanitizeLabel(m.applyPathPipeline(path))
+}
+
+// trimTrailingSlash strips a trailing slash, except from the root path
+func trimTrailingSlash(path string) string {
+	if len(path) > 1 && strings.HasSuffix(path, "/") {
+		return path[:len(path)-1]
+	}
+	return path
+}
+
+// patternPath strips the method from a ServeMux pattern
+func patternPath(pattern string) string {
+	if pattern == "" {
+		return UnmatchedPathLabel
+	}
+	if i := strings.IndexAny(pattern, " \t"); i >= 0 {
+		return strings.TrimLeft(pattern[i:], " \t")
+	}
+	return pattern
+}
+
+// firstSegment returns the leading "/segment" of the path
+func firstSegment(path string) string {
+	rest := strings.TrimPrefix(path, "/")
+	if i := strings.IndexByte(rest, '/'); i >= 0 {
+		rest = rest[:i]
+	}
+	return "/" + rest
+}
+
+// pathDepth returns the number of non-empty segments of the path
+func pathDepth(path string) string {
+	depth := 0
+	for _, segment := range strings.Split(path, "/") {
+		if segment != "" {
+			depth++
+		}
+	}
+	if depth >= maxPathDepth {
+		return strconv.Itoa(maxPathDepth) + "+"
+	}
+	return strconv.Itoa(depth)
+}
+
+func (m *Metrics) unknownPathLabel() string {
+	return labelOrDefault(m.cfg.UnknownPathLabel, DefaultUnknownPathLabel)
+}
+
+// labelOrDefault returns label, or def if it is empty
+func labelOrDefault(label, def string) string {
+	if label != "" {
+		return label
+	}
+	return def
+}
@@ -0,0 +1,126 @@
+package prommonitoring
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestPatternPathLabel(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.PathLabelMode = PathLabelPattern
+	m, reg := newTestMetrics(t, cfg)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /users/{id}", func(w http.ResponseWriter, r *http.Request) {})
+	handler := m.Middleware(mux)
+
+	for _, path := range []string{"/users/42", "/wp-login.php"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	for label, want := range map[string]float64{
+		"/users/{id}":      1,
+		UnmatchedPathLabel: 1,
+		"/users/42":        0,
+		"/wp-login.php":    0,
+	} {
+		if got := counterValue(t, reg, "app_http_requests_total", map[string]string{"path": label}); got != want {
+			t.Errorf("requests with path %q = %v, want %v", label, got, want)
+		}
+	}
+}
+
+func TestPathlessRequestLabels(t *testing.T) {
+	connect := func() *http.Request {
+		r := httptest.NewRequest(http.MethodConnect, "http://example.com:443", nil)
+		r.URL = &url.URL{Host: "example.com:443"}
+		r.RequestURI = "example.com:443"
+		return r
+	}
+	asterisk := func() *http.Request {
+		r := httptest.NewRequest(http.MethodOptions, "/", nil)
+		r.URL = &url.URL{Path: "*"}
+		r.RequestURI = "*"
+		return r
+	}
+	// Requests built by hand may only carry the target in RequestURI
+	bareAsterisk := func() *http.Request {
+		r := httptest.NewRequest(http.MethodOptions, "/", nil)
+		r.URL = &url.URL{}
+		r.RequestURI = "*"
+		return r
+	}
+	empty := func() *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.URL = &url.URL{}
+		return r
+	}
+
+	for _, tc := range []struct {
+		name    string
+		cfg     func(*Config)
+		request func() *http.Request
+		want    string
+	}{
+		{name: "connect", request: connect, want: DefaultConnectPathLabel},
+		{name: "options asterisk", request: asterisk, want: DefaultAsteriskPathLabel},
+		{name: "options asterisk without path", request: bareAsterisk, want: DefaultAsteriskPathLabel},
+		{name: "empty path", request: empty, want: DefaultUnknownPathLabel},
+		{
+			name:    "configured connect",
+			cfg:     func(cfg *Config) { cfg.ConnectPathLabel = "tunnel" },
+			request: connect,
+			want:    "tunnel",
+		},
+		{
+			name:    "configured asterisk",
+			cfg:     func(cfg *Config) { cfg.AsteriskPathLabel = "server-wide" },
+			request: asterisk,
+			want:    "server-wide",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			if tc.cfg != nil {
+				tc.cfg(cfg)
+			}
+			m, reg := newTestMetrics(t, cfg)
+
+			handler := m.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			handler.ServeHTTP(httptest.NewRecorder(), tc.request())
+
+			if got := counterValue(t, reg, "app_http_requests_total", map[string]string{"path": tc.want}); got != 1 {
+				t.Errorf("requests with path %q = %v, want 1 (series: %v)", tc.want, got, findMetric(t, reg, "app_http_requests_total", nil))
+			}
+		})
+	}
+}
+
+func TestNormalizeTrailingSlash(t *testing.T) {
+	tests := []struct {
+		path      string
+		normalize bool
+		want      string
+	}{
+		{path: "/", normalize: true, want: "/"},
+		{path: "/x", normalize: true, want: "/x"},
+		{path: "/x/", normalize: true, want: "/x"},
+		{path: "/", want: "/"},
+		{path: "/x", want: "/x"},
+		{path: "/x/", want: "/x/"},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s normalize=%v", tt.path, tt.normalize), func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.NormalizeTrailingSlash = tt.normalize
+			m := NewMetricsWithConfig(cfg)
+
+			if got := m.pathLabel(httptest.NewRequest(http.MethodGet, tt.path, nil)); got != tt.want {
+				t.Errorf("path label of %s = %q, want %q", tt.path, got, tt.want)
+			}
+		})
+	}
+}
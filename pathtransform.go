@@ -0,0 +1,112 @@
+package prommonitoring
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// PathTransform rewrites a path label value. Transforms are chained by
+// Config.PathPipeline and must be safe for concurrent use.
+type PathTransform func(string) string
+
+// IDPlaceholder replaces the ID segments rewritten by NormalizeIDs
+const IDPlaceholder = ":id"
+
+// CappedPathLabel is the path label of requests beyond the limit of
+// CapCardinality
+const CappedPathLabel = "<other>"
+
+// NormalizeIDs replaces numeric, UUID and long hex segments with
+// IDPlaceholder, so /users/123 becomes /users/:id
+func NormalizeIDs(path string) string {
+	if !strings.ContainsFunc(path, isDigit) {
+		return path
+	}
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if isIDSegment(segment) {
+			segments[i] = IDPlaceholder
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// CollapseToSegments keeps at most the first n segments of the path, so
+// with n = 2 /api/v1/users/123 becomes /api/v1. It panics if n is below 1.
+func CollapseToSegments(n int) PathTransform {
+	if n < 1 {
+		panic(fmt.Sprintf("prommonitoring: CollapseToSegments needs at least 1 segment, got %d", n))
+	}
+	return func(path string) string {
+		rest := strings.TrimPrefix(path, "/")
+		for i, c := 0, 0; i < len(rest); i++ {
+			if rest[i] != '/' {
+				continue
+			}
+			if c++; c == n {
+				return "/" + rest[:i]
+			}
+		}
+		return path
+	}
+}
+
+// CapCardinality passes through the first n distinct paths it sees and
+// maps every other one to CappedPathLabel, bounding the number of series
+// however many URLs clients request. It panics if n is below 1.
+func CapCardinality(n int) PathTransform {
+	if n < 1 {
+		panic(fmt.Sprintf("prommonitoring: CapCardinality needs a limit of at least 1, got %d", n))
+	}
+	var mu sync.Mutex
+	seen := make(map[string]struct{}, n)
+	return func(path string) string {
+		mu.Lock()
+		defer mu.Unlock()
+		if _, ok := seen[path]; ok {
+			return path
+		}
+		if len(seen) >= n {
+			return CappedPathLabel
+		}
+		seen[path] = struct{}{}
+		return path
+	}
+}
+
+// applyPathPipeline runs the configured transforms in order
+func (m *Metrics) applyPathPipeline(path string) string {
+	for _, transform := range m.cfg.PathPipeline {
+		path = transform(path)
+	}
+	return path
+}
+
+// isIDSegment reports whether a path segment looks like an identifier
+func isIDSegment(segment string) bool {
+	if segment == "" {
+		return false
+	}
+	if !strings.ContainsFunc(segment, func(r rune) bool { return !isDigit(r) }) {
+		return true
+	}
+	if len(segment) == 36 && strings.Count(segment, "-") == 4 {
+		return isHex(strings.ReplaceAll(segment, "-", ""))
+	}
+	return len(segment) >= 16 && isHex(segment)
+}
+
+func isDigit(r rune) bool {
+	return r >= '0' && r <= '9'
+}
+
+func isHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,84 @@
+package prommonitoring
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNormalizeIDs(t *testing.T) {
+	for path, want := range map[string]string{
+		"/users":                "/users",
+		"/users/123":            "/users/:id",
+		"/users/123/orders/456": "/users/:id/orders/:id",
+		"/items/3f2c1a9e-8b7d-4c6e-9f1a-2b3c4d5e6f70": "/items/:id",
+		"/v2/users": "/v2/users",
+	} {
+		if got := NormalizeIDs(path); got != want {
+			t.Errorf("NormalizeIDs(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestCollapseToSegments(t *testing.T) {
+	collapse := CollapseToSegments(2)
+	for path, want := range map[string]string{
+		"/":                  "/",
+		"/api":               "/api",
+		"/api/v1":            "/api/v1",
+		"/api/v1/users/123":  "/api/v1",
+		"/api/v1/users/123/": "/api/v1",
+	} {
+		if got := collapse(path); got != want {
+			t.Errorf("CollapseToSegments(2)(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestCapCardinality(t *testing.T) {
+	capped := CapCardinality(2)
+	for _, tt := range []struct{ path, want string }{
+		{"/a", "/a"},
+		{"/b", "/b"},
+		{"/c", CappedPathLabel},
+		{"/a", "/a"},
+	} {
+		if got := capped(tt.path); got != tt.want {
+			t.Errorf("CapCardinality(2)(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestPathTransformsRejectInvalidArguments(t *testing.T) {
+	for name, build := range map[string]func(){
+		"CollapseToSegments(0)": func() { CollapseToSegments(0) },
+		"CapCardinality(0)":     func() { CapCardinality(0) },
+		"CapCardinality(-1)":    func() { CapCardinality(-1) },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s did not panic", name)
+				}
+			}()
+			build()
+		}()
+	}
+}
+
+func TestPathPipelineComposition(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.PathPipeline = []PathTransform{NormalizeIDs, CollapseToSegments(3), CapCardinality(2)}
+	m := NewMetricsWithConfig(cfg)
+
+	for _, tt := range []struct{ path, want string }{
+		{"/users/123/orders/456", "/users/:id/orders"},
+		{"/users/789/orders", "/users/:id/orders"},
+		{"/health", "/health"},
+		{"/other", CappedPathLabel},
+	} {
+		if got := m.pathLabel(httptest.NewRequest(http.MethodGet, tt.path, nil)); got != tt.want {
+			t.Errorf("path label of %q = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package prommonitoring
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tokenBucket is a minimal token bucket rate limiter
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// allow takes a token if one is available. Otherwise it returns false and
+// the time until the next token.
+func (b *tokenBucket) allow(now time.Time) (bool, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// RateLimitMiddleware rejects requests arriving faster than perSecond, with
+// bursts of up to burst requests, answering 429 with a Retry-After header.
+// It is meant for SetupMetricsServer's middleware list, to protect the
+// exporter from runaway scrape configurations. A non-positive rate
+// disables limiting.
+func RateLimitMiddleware(perSecond float64, burst int) func(http.Handler) http.Handler {
+	if perSecond <= 0 {
+		return func(next http.Handler) http.Handler { return next }
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	bucket := &tokenBucket{
+		rate:   perSecond,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ok, wait := bucket.allow(time.Now())
+			if !ok {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// retryAfterBuckets covers Retry-After delays from a second to an hour
+var retryAfterBuckets = []float64{1, 2, 5, 10, 30, 60, 120, 300, 600, 1800, 3600}
+
+// observeRateLimited counts a 429 response and records its Retry-After if
+// it is a number of seconds
+func (m *Metrics) observeRateLimited(t *trackedRequest, info *requestInfo) {
+	labels := m.labelValues(m.routeLabels, info)
+	m.RateLimited.WithLabelValues(labels...).Inc()
+
+	// The header as sent, or as left by the handler if it wrote nothing
+	retryAfter := t.recorder.Header().Get("Retry-After")
+	if mw := t.metricsWriter; mw != nil {
+		if sent := mw.retryAfter.Load(); sent != nil {
+			retryAfter = *sent
+		}
+	}
+	if seconds, err := strconv.ParseUint(strings.TrimSpace(retryAfter), 10, 63); err == nil {
+		m.RetryAfter.WithLabelValues(labels...).Observe(float64(seconds))
+	}
+}
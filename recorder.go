@@ -0,0 +1,68 @@
+package prommonitoring
+
+import (
+	"net/http"
+	"time"
+)
+
+// Recorder is the part of *Metrics handlers use to record their own
+// observations. Handlers can depend on it instead of *Metrics and be given
+// a fake in tests, without touching the prometheus client.
+type Recorder interface {
+	// RecordRequest records a request handled outside Middleware in the
+	// request counter, the duration histogram and the error counter
+	RecordRequest(r *http.Request, statusCode int, duration time.Duration)
+	// RecordError counts an error of the given type for the request
+	RecordError(r *http.Request, errorType string)
+}
+
+var _ Recorder = (*Metrics)(nil)
+
+// RecordRequest implements Recorder. Without the response header, the
+// format label is "other"; use RecordResponse to provide it.
+func (m *Metrics) RecordRequest(r *http.Request, statusCode int, duration time.Duration) {
+	m.RecordResponse(r, statusCode, nil, duration)
+}
+
+// RecordResponse is RecordRequest with the header of the response, from
+// which labels such as format are derived
+func (m *Metrics) RecordResponse(r *http.Request, statusCode int, header http.Header, duration time.Duration) {
+	if m.disabled {
+		return
+	}
+	m.recordRequest(r, m.pathLabel(r), statusCode, header, duration)
+}
+
+// recordRequest records a request with the given path label
+func (m *Metrics) recordRequest(r *http.Request, path string, statusCode int, header http.Header, duration time.Duration) {
+	info := &requestInfo{
+		request:   r,
+		method:    r.Method,
+		path:      path,
+		router:    DefaultRouterLabel,
+		coldStart: isColdStart(),
+	}
+	m.observeResponse(info, statusCode, header, duration.Seconds(), true)
+}
+
+// RecordError implements Recorder
+func (m *Metrics) RecordError(r *http.Request, errorType string) {
+	if m.disabled {
+		return
+	}
+	info := &requestInfo{request: r, method: r.Method, path: m.pathLabel(r), errorType: errorType}
+	m.TotalErrors.WithLabelValues(m.labelValues(m.errorLabels, info)...).Inc()
+}
+
+// NotFoundHandler answers 404 Not Found and records the request with the
+// UnmatchedPathLabel path, so requests no route matched are counted
+// without creating a series per URL. Register it as a mux's catch-all.
+func (m *Metrics) NotFoundHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := m.now()
+		http.NotFound(w, r)
+		if !m.disabled {
+			m.recordRequest(r, UnmatchedPathLabel, http.StatusNotFound, w.Header(), since(m.now, start))
+		}
+	})
+}
@@ -0,0 +1,56 @@
+package prommonitoring
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRecordRequestSharesMiddlewareFanOut(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.CountStatusByMethod = true
+	cfg.CountSuccesses = true
+	cfg.FormatLabel = true
+	m, reg := newTestMetrics(t, cfg)
+
+	req := httptest.NewRequest(http.MethodPost, "/orders", nil)
+	m.RecordRequest(req, http.StatusCreated, 10*time.Millisecond)
+	header := http.Header{"Content-Type": {"application/json"}}
+	m.RecordResponse(req, http.StatusOK, header, 10*time.Millisecond)
+	m.RecordRequest(req, http.StatusBadGateway, 10*time.Millisecond)
+
+	for _, tt := range []struct {
+		name   string
+		labels map[string]string
+		want   float64
+	}{
+		{"app_http_requests_total", map[string]string{"status": "201", "format": "other"}, 1},
+		{"app_http_requests_total", map[string]string{"status": "200", "format": "json"}, 1},
+		{"app_http_requests_by_status_method", map[string]string{"method": "POST", "status_code": "201"}, 1},
+		{"app_http_requests_by_status_method", map[string]string{"method": "POST", "status_code": "502"}, 1},
+		{"app_http_success_total", map[string]string{"path": "/orders"}, 2},
+		{"app_http_errors_total", map[string]string{"path": "/orders"}, 1},
+	} {
+		if got := counterValue(t, reg, tt.name, tt.labels); got != tt.want {
+			t.Errorf("%s%v = %v, want %v", tt.name, tt.labels, got, tt.want)
+		}
+	}
+}
+
+func TestNotFoundHandlerRecordsFormat(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.FormatLabel = true
+	m, reg := newTestMetrics(t, cfg)
+
+	rec := httptest.NewRecorder()
+	m.NotFoundHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/nope", nil))
+
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("status = %d, want 404", rec.Code)
+	}
+	labels := map[string]string{"path": UnmatchedPathLabel, "status": "404", "format": "other"}
+	if got := counterValue(t, reg, "app_http_requests_total", labels); got != 1 {
+		t.Errorf("requests%v = %v, want 1", labels, got)
+	}
+}
@@ -0,0 +1,82 @@
+package prommonitoring
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// RegistrationError reports a metric of this package that couldn't be
+// registered, typically because another collector on a shared registry
+// already uses the same fully-qualified name
+type RegistrationError struct {
+	// Metric is the fully-qualified name of the metric that failed
+	Metric string
+	Err    error
+}
+
+func (e *RegistrationError) Error() string {
+	var already prometheus.AlreadyRegisteredError
+	if errors.As(e.Err, &already) {
+		return fmt.Sprintf("prommonitoring: metric %q is already registered by another collector; "+
+			"set a distinct Config.Namespace to avoid the collision", e.Metric)
+	}
+	return fmt.Sprintf("prommonitoring: registering metric %q: %v; "+
+		"if it collides with another collector, set a distinct Config.Namespace", e.Metric, e.Err)
+}
+
+func (e *RegistrationError) Unwrap() error {
+	return e.Err
+}
+
+// NewMetricsWithRegisterer creates all Prometheus metrics and registers
+// them on reg only, returning a *RegistrationError if one collides
+func NewMetricsWithRegisterer(cfg *Config, reg prometheus.Registerer) (*Metrics, error) {
+	m, err := newMetricsE(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.register(reg); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// register registers the enabled metrics one by one, so a failure can be
+// attributed to a specific metric. On failure, the metrics registered so
+// far are unregistered again; the colliding collector is left alone, as
+// unregistering ours would remove it, having the same descriptors.
+func (m *Metrics) register(reg prometheus.Registerer) error {
+	m.registerer = reg
+	collectors := m.collectors()
+	for i, c := range collectors {
+		if err := reg.Register(c); err != nil {
+			for _, registered := range collectors[:i] {
+				reg.Unregister(registered)
+			}
+			return &RegistrationError{Metric: collectorName(c), Err: err}
+		}
+	}
+	return nil
+}
+
+// collectorName returns the fully-qualified name of a single-metric
+// collector, as found in its descriptor
+func collectorName(c prometheus.Collector) string {
+	ch := make(chan *prometheus.Desc, 1)
+	go func() {
+		c.Describe(ch)
+		close(ch)
+	}()
+
+	var name string
+	for desc := range ch {
+		if name == "" {
+			_, rest, _ := strings.Cut(desc.String(), `fqName: "`)
+			name, _, _ = strings.Cut(rest, `"`)
+		}
+	}
+	return name
+}
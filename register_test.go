@@ -0,0 +1,108 @@
+package prommonitoring
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// assertNotOnDefaultRegistry fails if the collectors of m are registered
+// on the default registry, which rejects registering them again
+func assertNotOnDefaultRegistry(t *testing.T, m *Metrics) {
+	t.Helper()
+	for _, c := range m.collectors() {
+		if err := prometheus.DefaultRegisterer.Register(c); err != nil {
+			var already prometheus.AlreadyRegisteredError
+			if errors.As(err, &already) {
+				t.Errorf("%s is registered on the default registry", collectorName(c))
+				continue
+			}
+			t.Fatalf("registering %s: %v", collectorName(c), err)
+		}
+		prometheus.DefaultRegisterer.Unregister(c)
+	}
+}
+
+func TestConstructorsDoNotRegister(t *testing.T) {
+	for name, m := range map[string]*Metrics{
+		"NewMetrics":           NewMetrics("ctor"),
+		"NewMetricsWithConfig": NewMetricsWithConfig(&Config{Namespace: "ctor_cfg"}),
+	} {
+		t.Run(name, func(t *testing.T) {
+			serveOne(m)
+			assertNotOnDefaultRegistry(t, m)
+		})
+	}
+}
+
+func TestInitMetricsWithoutRegistryLeavesDefaultUntouched(t *testing.T) {
+	resetGlobalMetrics(t)
+
+	cfg := &Config{Namespace: "init_nil", MetricsPath: "/metrics"}
+	m, err := InitMetricsE(cfg)
+	if err != nil {
+		t.Fatalf("InitMetricsE: %v", err)
+	}
+	if cfg.Registry == nil {
+		t.Fatal("cfg.Registry was not assigned")
+	}
+	serveOne(m)
+
+	assertNotOnDefaultRegistry(t, m)
+	if got := counterValue(t, cfg.Registry, "init_nil_http_requests_total", nil); got != 1 {
+		t.Errorf("requests on cfg.Registry = %v, want 1", got)
+	}
+}
+
+func TestInitMetricsWithCustomRegistry(t *testing.T) {
+	resetGlobalMetrics(t)
+
+	reg := prometheus.NewRegistry()
+	m, err := InitMetricsE(&Config{Namespace: "init_custom", Registry: reg})
+	if err != nil {
+		t.Fatalf("InitMetricsE: %v", err)
+	}
+	serveOne(m)
+
+	assertNotOnDefaultRegistry(t, m)
+	if got := counterValue(t, reg, "init_custom_http_requests_total", nil); got != 1 {
+		t.Errorf("requests on the custom registry = %v, want 1", got)
+	}
+}
+
+func TestNewMetricsWithRegisterer(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m, err := NewMetricsWithRegisterer(&Config{Namespace: "with_reg"}, reg)
+	if err != nil {
+		t.Fatalf("NewMetricsWithRegisterer: %v", err)
+	}
+	serveOne(m)
+
+	assertNotOnDefaultRegistry(t, m)
+	if got := counterValue(t, reg, "with_reg_http_requests_total", nil); got != 1 {
+		t.Errorf("requests on reg = %v, want 1", got)
+	}
+}
+
+func TestNewMetricsWithRegistererCollision(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	cfg := &Config{Namespace: "collide"}
+	first, err := NewMetricsWithRegisterer(cfg, reg)
+	if err != nil {
+		t.Fatalf("first registration: %v", err)
+	}
+
+	_, err = NewMetricsWithRegisterer(cfg, reg)
+	var regErr *RegistrationError
+	if !errors.As(err, &regErr) {
+		t.Fatalf("second registration error = %v, want a *RegistrationError", err)
+	}
+
+	// The failed registration must not take the first instance's
+	// collectors with it
+	serveOne(first)
+	if got := counterValue(t, reg, "collide_http_requests_total", nil); got != 1 {
+		t.Errorf("requests of the first instance = %v, want 1", got)
+	}
+}
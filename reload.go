@@ -0,0 +1,102 @@
+package prommonitoring
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// reconfigureMu serializes Reconfigure and guards metricsErr
+var reconfigureMu sync.Mutex
+
+// Reconfigure replaces the metrics instance returned by GetMetrics with one
+// built from cfg, e.g. with new buckets or a new namespace after a config
+// reload. The old collectors are unregistered from their registry and the
+// new ones registered on cfg.Registry, which may be a different registry.
+//
+// The switch is atomic for request handling: requests record against
+// whichever instance was active when they started, and never against a
+// half-built one. It is not atomic for scrapes, which may briefly see
+// neither instance while the collectors are swapped. If the new metrics
+// can't be registered, the old instance is restored and the error
+// returned. If InitMetrics failed, Reconfigure replaces the failed
+// instance, and a later InitMetrics returns the new one.
+//
+// Only middleware that looks up the active instance per request, such as
+// ReloadableMiddleware, follows a reconfiguration; handlers wrapped with a
+// specific instance's Middleware keep recording to that instance.
+func Reconfigure(cfg *Config) (*Metrics, error) {
+	if cfg == nil {
+		cfg = DefaultConfig()
+	}
+
+	// Settle InitMetrics first, so that it can't later overwrite the
+	// instance stored here. Done before locking, as it locks itself.
+	metricsOnce.Do(func() {})
+
+	reconfigureMu.Lock()
+	defer reconfigureMu.Unlock()
+
+	// A failed instance has nothing registered to swap out
+	old := metrics.Load()
+	if old != nil && metricsErr == nil {
+		old.unregister(old.cfg.registry())
+	} else {
+		old = nil
+	}
+
+	m, err := NewMetricsWithRegisterer(cfg, cfg.registry())
+	if err != nil {
+		if old == nil {
+			return metrics.Load(), err
+		}
+		_ = old.register(old.cfg.registry())
+		return old, err
+	}
+
+	metrics.Store(m)
+	metricsErr = nil
+	return m, nil
+}
+
+// ReloadableMiddleware instruments requests with the instance active at
+// the time each request starts, so it follows Reconfigure. The wrapped
+// handler is built once per instance, not per request.
+func ReloadableMiddleware(next http.Handler) http.Handler {
+	type instrumented struct {
+		m *Metrics
+		h http.Handler
+	}
+	var current atomic.Pointer[instrumented]
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m := GetMetrics()
+		c := current.Load()
+		if c == nil || c.m != m {
+			c = &instrumented{m: m, h: m.Middleware(next)}
+			current.Store(c)
+		}
+		c.h.ServeHTTP(w, r)
+	})
+}
+
+// newMetricsE is NewMetricsWithConfig returning construction panics, e.g.
+// from invalid buckets, as errors
+func newMetricsE(cfg *Config) (m *Metrics, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("prommonitoring: creating metrics: %v", r)
+		}
+	}()
+	return NewMetricsWithConfig(cfg), nil
+}
+
+// unregister removes the enabled metrics from the registerer
+func (m *Metrics) unregister(reg prometheus.Registerer) {
+	for _, c := range m.collectors() {
+		reg.Unregister(c)
+	}
+}
@@ -0,0 +1,107 @@
+package prommonitoring
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestReconfigureRecoversFromFailedInit(t *testing.T) {
+	resetGlobalMetrics(t)
+
+	// Occupy the request counter's name so InitMetricsE fails
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(prometheus.NewCounter(prometheus.CounterOpts{Name: "failed_http_requests_total", Help: "taken"}))
+	if _, err := InitMetricsE(&Config{Namespace: "failed", Registry: reg}); err == nil {
+		t.Fatal("InitMetricsE succeeded despite the collision")
+	}
+
+	cfg := &Config{Namespace: "recovered", Registry: prometheus.NewRegistry()}
+	m, err := Reconfigure(cfg)
+	if err != nil {
+		t.Fatalf("Reconfigure: %v", err)
+	}
+	if got, err := InitMetricsE(nil); got != m || err != nil {
+		t.Fatalf("InitMetricsE after Reconfigure = %p, %v; want %p, nil", got, err, m)
+	}
+	if GetMetrics() != m {
+		t.Fatal("GetMetrics does not return the reconfigured instance")
+	}
+
+	serveOne(m)
+	if got := counterValue(t, cfg.Registry, "recovered_http_requests_total", nil); got != 1 {
+		t.Errorf("requests = %v, want 1", got)
+	}
+}
+
+func TestReconfigureBeforeInit(t *testing.T) {
+	resetGlobalMetrics(t)
+
+	cfg := &Config{Namespace: "early", Registry: prometheus.NewRegistry()}
+	m, err := Reconfigure(cfg)
+	if err != nil {
+		t.Fatalf("Reconfigure: %v", err)
+	}
+	// InitMetrics must not replace the instance set by Reconfigure
+	if got := InitMetrics(&Config{Namespace: "late"}); got != m {
+		t.Fatal("InitMetrics replaced the reconfigured instance")
+	}
+}
+
+func TestReconfigureCollisionKeepsOldInstance(t *testing.T) {
+	resetGlobalMetrics(t)
+
+	cfg := &Config{Namespace: "kept", Registry: prometheus.NewRegistry()}
+	old, err := InitMetricsE(cfg)
+	if err != nil {
+		t.Fatalf("InitMetricsE: %v", err)
+	}
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(prometheus.NewCounter(prometheus.CounterOpts{Name: "clash_http_requests_total", Help: "taken"}))
+	m, err := Reconfigure(&Config{Namespace: "clash", Registry: reg})
+	var regErr *RegistrationError
+	if !errors.As(err, &regErr) {
+		t.Fatalf("Reconfigure error = %v, want a *RegistrationError", err)
+	}
+	if m != old || GetMetrics() != old {
+		t.Fatal("the old instance was not kept")
+	}
+
+	serveOne(old)
+	if got := counterValue(t, cfg.Registry, "kept_http_requests_total", nil); got != 1 {
+		t.Errorf("requests of the old instance = %v, want 1", got)
+	}
+}
+
+func TestReloadableMiddlewareFollowsReconfigure(t *testing.T) {
+	resetGlobalMetrics(t)
+
+	first := &Config{Namespace: "before", Registry: prometheus.NewRegistry()}
+	if _, err := InitMetricsE(first); err != nil {
+		t.Fatalf("InitMetricsE: %v", err)
+	}
+	h := ReloadableMiddleware(http.NotFoundHandler())
+	serve := func() {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/x", nil))
+	}
+
+	serve()
+	serve()
+	if got := counterValue(t, first.Registry, "before_http_requests_total", nil); got != 2 {
+		t.Errorf("requests before Reconfigure = %v, want 2", got)
+	}
+
+	second := &Config{Namespace: "after", Registry: prometheus.NewRegistry()}
+	if _, err := Reconfigure(second); err != nil {
+		t.Fatalf("Reconfigure: %v", err)
+	}
+	serve()
+
+	if got := counterValue(t, second.Registry, "after_http_requests_total", nil); got != 1 {
+		t.Errorf("requests after Reconfigure = %v, want 1", got)
+	}
+}
@@ -0,0 +1,211 @@
+// Package remotewrite pushes metrics from a registry to a Prometheus
+// remote-write receiver, for environments where neither scraping nor a
+// Pushgateway is available (e.g. short-lived serverless functions).
+package remotewrite
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Option configures a RemoteWrite call
+type Option func(*options)
+
+type options struct {
+	client  *http.Client
+	headers http.Header
+	now     func() time.Time
+}
+
+// WithHTTPClient sets the HTTP client used to send the request
+func WithHTTPClient(client *http.Client) Option {
+	return func(o *options) {
+		o.client = client
+	}
+}
+
+// WithHeader adds a header to the request, e.g. a tenant ID
+func WithHeader(key, value string) Option {
+	return func(o *options) {
+		o.headers.Add(key, value)
+	}
+}
+
+// WithBearerToken sets an "Authorization: Bearer" header
+func WithBearerToken(token string) Option {
+	return func(o *options) {
+		o.headers.Set("Authorization", "Bearer "+token)
+	}
+}
+
+// WithBasicAuth sets an "Authorization: Basic" header
+func WithBasicAuth(username, password string) Option {
+	return func(o *options) {
+		req := http.Request{Header: http.Header{}}
+		req.SetBasicAuth(username, password)
+		o.headers.Set("Authorization", req.Header.Get("Authorization"))
+	}
+}
+
+// RemoteWrite gathers all metrics from the registry, converts them into
+// remote-write time series, and POSTs them snappy-compressed to endpoint.
+// The request is bound to ctx, so its deadline limits the whole push.
+func RemoteWrite(ctx context.Context, endpoint string, reg *prometheus.Registry, opts ...Option) error {
+	o := &options{
+		client:  http.DefaultClient,
+		headers: http.Header{},
+		now:     time.Now,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	families, err := reg.Gather()
+	if err != nil {
+		return fmt.Errorf("remotewrite: gather: %w", err)
+	}
+
+	body := s2.EncodeSnappy(nil, encodeWriteRequest(families, o.now().UnixMilli()))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("remotewrite: build request: %w", err)
+	}
+	for key, values := range o.headers {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("remotewrite: send: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("remotewrite: server returned %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	// Drain the body so the connection can be reused
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	return nil
+}
+
+type label struct {
+	name  string
+	value string
+}
+
+// encodeWriteRequest builds a prometheus.WriteRequest protobuf message:
+//
+//	WriteRequest { repeated TimeSeries timeseries = 1; }
+//	TimeSeries   { repeated Label labels = 1; repeated Sample samples = 2; }
+//	Label        { string name = 1; string value = 2; }
+//	Sample       { double value = 1; int64 timestamp = 2; }
+func encodeWriteRequest(families []*dto.MetricFamily, nowMs int64) []byte {
+	var buf []byte
+
+	appendSeries := func(name string, base []label, extra *label, value float64, ts int64) {
+		labels := make([]label, 0, len(base)+2)
+		labels = append(labels, label{name: "__name__", value: name})
+		labels = append(labels, base...)
+		if extra != nil {
+			labels = append(labels, *extra)
+		}
+		sort.Slice(labels, func(i, j int) bool { return labels[i].name < labels[j].name })
+
+		var series []byte
+		for _, l := range labels {
+			var lb []byte
+			lb = protowire.AppendTag(lb, 1, protowire.BytesType)
+			lb = protowire.AppendString(lb, l.name)
+			lb = protowire.AppendTag(lb, 2, protowire.BytesType)
+			lb = protowire.AppendString(lb, l.value)
+
+			series = protowire.AppendTag(series, 1, protowire.BytesType)
+			series = protowire.AppendBytes(series, lb)
+		}
+
+		var sample []byte
+		sample = protowire.AppendTag(sample, 1, protowire.Fixed64Type)
+		sample = protowire.AppendFixed64(sample, math.Float64bits(value))
+		sample = protowire.AppendTag(sample, 2, protowire.VarintType)
+		sample = protowire.AppendVarint(sample, uint64(ts))
+
+		series = protowire.AppendTag(series, 2, protowire.BytesType)
+		series = protowire.AppendBytes(series, sample)
+
+		buf = protowire.AppendTag(buf, 1, protowire.BytesType)
+		buf = protowire.AppendBytes(buf, series)
+	}
+
+	for _, mf := range families {
+		name := mf.GetName()
+		for _, m := range mf.GetMetric() {
+			base := make([]label, 0, len(m.GetLabel()))
+			for _, lp := range m.GetLabel() {
+				base = append(base, label{name: lp.GetName(), value: lp.GetValue()})
+			}
+
+			ts := nowMs
+			if m.TimestampMs != nil {
+				ts = m.GetTimestampMs()
+			}
+
+			switch mf.GetType() {
+			case dto.MetricType_COUNTER:
+				appendSeries(name, base, nil, m.GetCounter().GetValue(), ts)
+			case dto.MetricType_GAUGE:
+				appendSeries(name, base, nil, m.GetGauge().GetValue(), ts)
+			case dto.MetricType_UNTYPED:
+				appendSeries(name, base, nil, m.GetUntyped().GetValue(), ts)
+			case dto.MetricType_HISTOGRAM, dto.MetricType_GAUGE_HISTOGRAM:
+				h := m.GetHistogram()
+				for _, b := range h.GetBucket() {
+					if math.IsInf(b.GetUpperBound(), +1) {
+						// Written below from the sample count
+						continue
+					}
+					le := label{name: "le", value: formatFloat(b.GetUpperBound())}
+					appendSeries(name+"_bucket", base, &le, float64(b.GetCumulativeCount()), ts)
+				}
+				inf := label{name: "le", value: "+Inf"}
+				appendSeries(name+"_bucket", base, &inf, float64(h.GetSampleCount()), ts)
+				appendSeries(name+"_sum", base, nil, h.GetSampleSum(), ts)
+				appendSeries(name+"_count", base, nil, float64(h.GetSampleCount()), ts)
+			case dto.MetricType_SUMMARY:
+				s := m.GetSummary()
+				for _, q := range s.GetQuantile() {
+					ql := label{name: "quantile", value: formatFloat(q.GetQuantile())}
+					appendSeries(name, base, &ql, q.GetValue(), ts)
+				}
+				appendSeries(name+"_sum", base, nil, s.GetSampleSum(), ts)
+				appendSeries(name+"_count", base, nil, float64(s.GetSampleCount()), ts)
+			}
+		}
+	}
+
+	return buf
+}
+
+func formatFloat(f float64) string {
+	if math.IsInf(f, +1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,262 @@
+package remotewrite
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// series is a decoded remote-write time series with a single sample
+type series struct {
+	labels    []label
+	value     float64
+	timestamp int64
+}
+
+// name returns the __name__ label of the series
+func (s series) name() string {
+	for _, l := range s.labels {
+		if l.name == "__name__" {
+			return l.value
+		}
+	}
+	return ""
+}
+
+// get returns the value of a label of the series
+func (s series) get(name string) string {
+	for _, l := range s.labels {
+		if l.name == name {
+			return l.value
+		}
+	}
+	return ""
+}
+
+// received is a request captured by the test receiver
+type received struct {
+	header http.Header
+	series []series
+}
+
+// newReceiver starts a remote-write receiver passing every request it
+// decodes to the returned channel
+func newReceiver(t *testing.T) (*httptest.Server, <-chan received) {
+	t.Helper()
+	requests := make(chan received, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		compressed, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("reading body: %v", err)
+			return
+		}
+		body, err := s2.Decode(nil, compressed)
+		if err != nil {
+			t.Errorf("snappy-decoding body: %v", err)
+			return
+		}
+		requests <- received{header: r.Header, series: decodeWriteRequest(t, body)}
+	}))
+	t.Cleanup(server.Close)
+	return server, requests
+}
+
+// decodeWriteRequest parses a WriteRequest protobuf message
+func decodeWriteRequest(t *testing.T, b []byte) []series {
+	t.Helper()
+	var out []series
+	for _, ts := range fields(t, b, 1) {
+		var s series
+		for _, lb := range fields(t, ts, 1) {
+			s.labels = append(s.labels, label{
+				name:  string(fields(t, lb, 1)[0]),
+				value: string(fields(t, lb, 2)[0]),
+			})
+		}
+		samples := fields(t, ts, 2)
+		if len(samples) != 1 {
+			t.Fatalf("series %v has %d samples, want 1", s.labels, len(samples))
+		}
+		s.value = math.Float64frombits(scalar(t, samples[0], 1))
+		s.timestamp = int64(scalar(t, samples[0], 2))
+		out = append(out, s)
+	}
+	return out
+}
+
+// walk calls f with the number and raw value of every field of a message
+func walk(t *testing.T, b []byte, f func(num protowire.Number, typ protowire.Type, value []byte)) {
+	t.Helper()
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			t.Fatalf("invalid tag: %v", protowire.ParseError(n))
+		}
+		b = b[n:]
+		n = protowire.ConsumeFieldValue(num, typ, b)
+		if n < 0 {
+			t.Fatalf("invalid field %d: %v", num, protowire.ParseError(n))
+		}
+		f(num, typ, b[:n])
+		b = b[n:]
+	}
+}
+
+// fields returns the length-delimited fields numbered num of a message
+func fields(t *testing.T, b []byte, num protowire.Number) [][]byte {
+	t.Helper()
+	var out [][]byte
+	walk(t, b, func(n protowire.Number, typ protowire.Type, value []byte) {
+		if n == num && typ == protowire.BytesType {
+			v, _ := protowire.ConsumeBytes(value)
+			out = append(out, v)
+		}
+	})
+	return out
+}
+
+// scalar returns the fixed64 or varint field numbered num of a message
+func scalar(t *testing.T, b []byte, num protowire.Number) uint64 {
+	t.Helper()
+	var out uint64
+	walk(t, b, func(n protowire.Number, typ protowire.Type, value []byte) {
+		switch {
+		case n != num:
+		case typ == protowire.Fixed64Type:
+			out, _ = protowire.ConsumeFixed64(value)
+		case typ == protowire.VarintType:
+			out, _ = protowire.ConsumeVarint(value)
+		}
+	})
+	return out
+}
+
+func withNow(now time.Time) Option {
+	return func(o *options) {
+		o.now = func() time.Time { return now }
+	}
+}
+
+func TestRemoteWriteEncodesSeries(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	requests := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "requests_total", Help: "h"}, []string{"zone", "method"})
+	requests.WithLabelValues("eu", "GET").Add(3)
+	latency := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "latency_seconds", Help: "h", Buckets: []float64{1}})
+	latency.Observe(0.5)
+	latency.Observe(3)
+	reg.MustRegister(requests, latency)
+
+	server, received := newReceiver(t)
+	now := time.UnixMilli(1700000000000)
+	if err := RemoteWrite(context.Background(), server.URL, reg, withNow(now), WithHeader("X-Scope-OrgID", "tenant")); err != nil {
+		t.Fatalf("RemoteWrite: %v", err)
+	}
+	got := <-received
+
+	for key, want := range map[string]string{
+		"Content-Encoding":                  "snappy",
+		"Content-Type":                      "application/x-protobuf",
+		"X-Prometheus-Remote-Write-Version": "0.1.0",
+		"X-Scope-OrgID":                     "tenant",
+	} {
+		if v := got.header.Get(key); v != want {
+			t.Errorf("header %s = %q, want %q", key, v, want)
+		}
+	}
+
+	byKey := make(map[string]series)
+	for _, s := range got.series {
+		if s.timestamp != now.UnixMilli() {
+			t.Errorf("%v: timestamp %d, want %d", s.labels, s.timestamp, now.UnixMilli())
+		}
+		key := s.name() + "/" + s.get("le")
+		if _, dup := byKey[key]; dup {
+			t.Errorf("duplicate series %v", s.labels)
+		}
+		byKey[key] = s
+	}
+
+	counter := byKey["requests_total/"]
+	wantLabels := []label{{"__name__", "requests_total"}, {"method", "GET"}, {"zone", "eu"}}
+	if len(counter.labels) != len(wantLabels) {
+		t.Fatalf("counter labels = %v, want %v", counter.labels, wantLabels)
+	}
+	for i := range wantLabels {
+		if counter.labels[i] != wantLabels[i] {
+			t.Errorf("counter labels = %v, want %v", counter.labels, wantLabels)
+			break
+		}
+	}
+	if counter.value != 3 {
+		t.Errorf("counter value = %v, want 3", counter.value)
+	}
+
+	for key, want := range map[string]float64{
+		"latency_seconds_bucket/1":    1,
+		"latency_seconds_bucket/+Inf": 2,
+		"latency_seconds_sum/":        3.5,
+		"latency_seconds_count/":      2,
+	} {
+		s, ok := byKey[key]
+		if !ok {
+			t.Errorf("series %s missing", key)
+			continue
+		}
+		if s.value != want {
+			t.Errorf("%s = %v, want %v", key, s.value, want)
+		}
+	}
+}
+
+func TestRemoteWriteAuth(t *testing.T) {
+	for name, tc := range map[string]struct {
+		opt  Option
+		want string
+	}{
+		"basic":  {WithBasicAuth("user", "pass"), "Basic dXNlcjpwYXNz"},
+		"bearer": {WithBearerToken("secret"), "Bearer secret"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			server, received := newReceiver(t)
+			if err := RemoteWrite(context.Background(), server.URL, prometheus.NewRegistry(), tc.opt); err != nil {
+				t.Fatalf("RemoteWrite: %v", err)
+			}
+			if got := (<-received).header.Get("Authorization"); got != tc.want {
+				t.Errorf("Authorization = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRemoteWriteHonorsContextDeadline(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err := RemoteWrite(ctx, server.URL, prometheus.NewRegistry())
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("RemoteWrite error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("RemoteWrite returned after %v, past the deadline", elapsed)
+	}
+}
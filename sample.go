@@ -0,0 +1,39 @@
+package prommonitoring
+
+import (
+	"math/rand/v2"
+	"sync"
+)
+
+// sampler draws the sampling decisions of Config.HistogramSampleRate
+type sampler struct {
+	mu  sync.Mutex
+	rng *rand.Rand // nil for the runtime's per-goroutine generator
+}
+
+func newSampler(src rand.Source) *sampler {
+	if src == nil {
+		return &sampler{}
+	}
+	return &sampler{rng: rand.New(src)}
+}
+
+func (s *sampler) float64() float64 {
+	if s.rng == nil {
+		return rand.Float64()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rng.Float64()
+}
+
+// sampled reports whether the histograms observe the current request.
+// One number is drawn per request that would otherwise be observed, so a
+// fixed Config.RandSource yields reproducible decisions.
+func (m *Metrics) sampled() bool {
+	rate := m.cfg.HistogramSampleRate
+	if rate <= 0 || rate >= 1 {
+		return true
+	}
+	return m.sampler.float64() < rate
+}
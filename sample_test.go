@@ -0,0 +1,46 @@
+package prommonitoring
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHistogramSamplingWithFixedSource(t *testing.T) {
+	const rate, requests = 0.5, 20
+
+	cfg := DefaultConfig()
+	cfg.HistogramSampleRate = rate
+	cfg.RandSource = rand.NewPCG(1, 2)
+	m, reg := newTestMetrics(t, cfg)
+
+	// The same source draws the same decisions, one per request
+	expected := rand.New(rand.NewPCG(1, 2))
+	want := make(map[string]bool)
+	handler := m.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for i := range requests {
+		path := fmt.Sprintf("/r%d", i)
+		want[path] = expected.Float64() < rate
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	observed := 0
+	for path, sampled := range want {
+		labels := map[string]string{"path": path}
+		metric := findMetric(t, reg, "app_http_request_duration_seconds", labels)
+		if got := metric != nil && metric.GetHistogram().GetSampleCount() == 1; got != sampled {
+			t.Errorf("%s observed in the duration histogram: %v, want %v", path, got, sampled)
+		}
+		if sampled {
+			observed++
+		}
+		if got := counterValue(t, reg, "app_http_requests_total", labels); got != 1 {
+			t.Errorf("%s counted %v times, want 1", path, got)
+		}
+	}
+	if observed == 0 || observed == requests {
+		t.Fatalf("%d of %d requests sampled; pick a seed that exercises both outcomes", observed, requests)
+	}
+}
@@ -0,0 +1,40 @@
+package prommonitoring
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// scrapeDuration self-instruments the metrics endpoint. The histogram
+// lives on its own registry, gathered alongside the exposed one, so it is
+// never part of the registry whose generation time it measures.
+type scrapeDuration struct {
+	registry  *prometheus.Registry
+	histogram prometheus.Histogram
+}
+
+func newScrapeDuration(cfg *Config) *scrapeDuration {
+	s := &scrapeDuration{
+		registry: prometheus.NewRegistry(),
+		histogram: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace:   cfg.Namespace,
+			Name:        "http_metrics_scrape_duration_seconds",
+			Help:        "Time taken to generate and write the metrics exposition",
+			ConstLabels: cfg.constLabels(),
+			Buckets:     []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+		}),
+	}
+	s.registry.MustRegister(s.histogram)
+	return s
+}
+
+// middleware observes how long the wrapped metrics handler takes
+func (s *scrapeDuration) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		next.ServeHTTP(w, r)
+		s.histogram.Observe(time.Since(start).Seconds())
+	})
+}
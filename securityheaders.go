@@ -0,0 +1,85 @@
+package prommonitoring
+
+import "net/http"
+
+// DefaultSecurityHeaders returns common security headers, for
+// Config.SecurityHeaders
+func DefaultSecurityHeaders() []string {
+	return []string{
+		"Strict-Transport-Security",
+		"Content-Security-Policy",
+		"X-Content-Type-Options",
+		"X-Frame-Options",
+		"Referrer-Policy",
+	}
+}
+
+// SecurityHeadersMiddleware counts the responses lacking any of
+// Config.SecurityHeaders in http_responses_missing_security_headers_total,
+// checking the headers as they are sent. It only observes, unless
+// Config.SecurityHeaderValues provides a value to set for a missing
+// header; such responses are still counted as missing it. Without
+// Config.SecurityHeaders the middleware is a no-op.
+func (m *Metrics) SecurityHeadersMiddleware(next http.Handler) http.Handler {
+	if m.MissingSecurityHeaders == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sw := &securityHeaderWriter{ResponseWriter: w, m: m}
+		next.ServeHTTP(sw, r)
+		// Headers of responses without a body are sent after the handler
+		sw.check()
+	})
+}
+
+// securityHeaderWriter checks the security headers once, right before the
+// final header is sent
+type securityHeaderWriter struct {
+	http.ResponseWriter
+	m       *Metrics
+	checked bool
+}
+
+func (w *securityHeaderWriter) check() {
+	if w.checked {
+		return
+	}
+	w.checked = true
+
+	h := w.Header()
+	for _, name := range w.m.cfg.SecurityHeaders {
+		if h.Get(name) != "" {
+			continue
+		}
+		w.m.MissingSecurityHeaders.WithLabelValues(name).Inc()
+		if value, ok := w.m.cfg.SecurityHeaderValues[name]; ok {
+			h.Set(name, value)
+		}
+	}
+}
+
+func (w *securityHeaderWriter) WriteHeader(statusCode int) {
+	// Informational responses precede the final header
+	if statusCode < 100 || statusCode >= 200 || statusCode == http.StatusSwitchingProtocols {
+		w.check()
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *securityHeaderWriter) Write(b []byte) (int, error) {
+	w.check()
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush sends the headers, so they are checked first
+func (w *securityHeaderWriter) Flush() {
+	w.check()
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap returns the underlying writer for http.ResponseController
+func (w *securityHeaderWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
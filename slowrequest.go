@@ -0,0 +1,60 @@
+package prommonitoring
+
+import (
+	"runtime"
+	"sync"
+	"time"
+)
+
+// slowRequestProbe captures runtime statistics for a request once it has
+// been running for longer than Config.SlowRequestThreshold. Fast requests
+// never pay for runtime.ReadMemStats, which stops the world.
+type slowRequestProbe struct {
+	timer *time.Timer
+
+	mu         sync.Mutex
+	triggered  bool
+	done       bool
+	totalAlloc uint64
+}
+
+func (m *Metrics) startSlowRequestProbe() *slowRequestProbe {
+	p := &slowRequestProbe{}
+	p.timer = time.AfterFunc(m.cfg.SlowRequestThreshold, func() {
+		var ms runtime.MemStats
+		runtime.ReadMemStats(&ms)
+
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if p.done {
+			return
+		}
+		p.triggered = true
+		p.totalAlloc = ms.TotalAlloc
+	})
+	return p
+}
+
+// finish stops the probe and, if the request turned out to be slow,
+// records the goroutine count and the heap allocated since the threshold
+// was crossed. The allocation delta is process-wide, so it is a
+// correlation signal rather than an exact per-request figure.
+func (m *Metrics) finishSlowRequestProbe(p *slowRequestProbe, info *requestInfo) {
+	p.timer.Stop()
+
+	p.mu.Lock()
+	p.done = true
+	triggered, before := p.triggered, p.totalAlloc
+	p.mu.Unlock()
+
+	if !triggered {
+		return
+	}
+
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+
+	labels := m.labelValues(m.pathLabels, info)
+	m.SlowRequestGoroutines.WithLabelValues(labels...).Observe(float64(runtime.NumGoroutine()))
+	m.SlowRequestHeapAlloc.WithLabelValues(labels...).Observe(float64(ms.TotalAlloc - before))
+}
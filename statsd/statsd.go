@@ -0,0 +1,278 @@
+// Package statsd bridges a Prometheus registry to a StatsD daemon, for
+// pipelines that cannot scrape. It periodically gathers the registry and
+// emits the equivalent StatsD lines over UDP.
+package statsd
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// maxPacketSize keeps datagrams below the common 1500 byte MTU
+const maxPacketSize = 1432
+
+// Config holds the configuration for the StatsD exporter
+type Config struct {
+	// Address is the UDP address of the StatsD daemon, e.g. "127.0.0.1:8125"
+	Address string
+	// Interval between two exports
+	Interval time.Duration
+	// Prefix is prepended (with a dot) to every emitted name
+	Prefix string
+	// Gatherer is read on every tick, usually the Config.Registry the
+	// Metrics were registered on
+	Gatherer prometheus.Gatherer
+}
+
+// Exporter periodically emits the gathered metrics to StatsD:
+//
+//   - counters are sent as deltas since the previous export ("|c")
+//   - gauges are sent as absolute values ("|g")
+//   - histograms are approximated as timers ("|ms"): every new observation
+//     is reported at the upper bound of the bucket it fell into, using the
+//     sample rate to carry the count. Values of "_seconds" histograms are
+//     converted to milliseconds.
+//   - summary quantiles are sent as gauges
+//
+// Label values are appended to the metric name as dotted "key.value" pairs.
+type Exporter struct {
+	cfg  Config
+	conn net.Conn
+
+	// previous cumulative values, keyed by the emitted StatsD name, and
+	// the mutex serializing the exports that update them
+	mu   sync.Mutex
+	last map[string]float64
+
+	stop      chan struct{}
+	done      chan struct{}
+	startOnce sync.Once
+	stopOnce  sync.Once
+}
+
+// NewExporter creates an exporter and dials the StatsD address. Call Start
+// to begin exporting.
+func NewExporter(cfg Config) (*Exporter, error) {
+	if cfg.Gatherer == nil {
+		return nil, fmt.Errorf("statsd: a Gatherer is required")
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = 10 * time.Second
+	}
+
+	conn, err := net.Dial("udp", cfg.Address)
+	if err != nil {
+		return nil, fmt.Errorf("statsd: dial %s: %w", cfg.Address, err)
+	}
+
+	return &Exporter{
+		cfg:  cfg,
+		conn: conn,
+		last: make(map[string]float64),
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}, nil
+}
+
+// Start runs the export loop in a background goroutine. Calls after the
+// first, or after Stop, do nothing.
+func (e *Exporter) Start() {
+	e.startOnce.Do(func() {
+		go func() {
+			defer close(e.done)
+
+			ticker := time.NewTicker(e.cfg.Interval)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-ticker.C:
+					_ = e.Export()
+				case <-e.stop:
+					return
+				}
+			}
+		}()
+	})
+}
+
+// Stop ends the export loop if it was started, performs a final export
+// and closes the connection. It is safe to call more than once.
+func (e *Exporter) Stop() {
+	e.stopOnce.Do(func() {
+		// Without a loop to close done, mark it as finished ourselves
+		e.startOnce.Do(func() { close(e.done) })
+		close(e.stop)
+		<-e.done
+		_ = e.Export()
+		_ = e.conn.Close()
+	})
+}
+
+// Export gathers the metrics once and sends them. It is called on every
+// tick but can also be used directly to flush on demand.
+func (e *Exporter) Export() error {
+	families, err := e.cfg.Gatherer.Gather()
+	if err != nil && len(families) == 0 {
+		return fmt.Errorf("statsd: gather: %w", err)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var lines []string
+	for _, mf := range families {
+		lines = e.appendFamily(lines, mf)
+	}
+
+	return e.send(lines)
+}
+
+func (e *Exporter) appendFamily(lines []string, mf *dto.MetricFamily) []string {
+	for _, m := range mf.GetMetric() {
+		name := e.metricName(mf.GetName(), m.GetLabel())
+
+		switch mf.GetType() {
+		case dto.MetricType_COUNTER:
+			value := m.GetCounter().GetValue()
+			if delta := e.delta(name, value); delta > 0 {
+				lines = append(lines, name+":"+formatValue(delta)+"|c")
+			}
+		case dto.MetricType_GAUGE:
+			lines = append(lines, name+":"+formatValue(m.GetGauge().GetValue())+"|g")
+		case dto.MetricType_UNTYPED:
+			lines = append(lines, name+":"+formatValue(m.GetUntyped().GetValue())+"|g")
+		case dto.MetricType_HISTOGRAM:
+			scale := 1.0
+			if strings.HasSuffix(mf.GetName(), "_seconds") {
+				scale = 1000
+			}
+
+			h := m.GetHistogram()
+			var prevCount, prevBound float64
+			for _, b := range h.GetBucket() {
+				bound := b.GetUpperBound()
+				if math.IsInf(bound, +1) {
+					// Derived from the sample count below
+					continue
+				}
+				count := float64(b.GetCumulativeCount())
+				lines = e.appendBucket(lines, name, formatValue(bound), count-prevCount, bound*scale)
+				prevCount, prevBound = count, bound
+			}
+
+			// Observations above the last bound are in no bucket and are
+			// reported at that bound
+			overflow := float64(h.GetSampleCount()) - prevCount
+			lines = e.appendBucket(lines, name, "+Inf", overflow, prevBound*scale)
+		case dto.MetricType_SUMMARY:
+			for _, q := range m.GetSummary().GetQuantile() {
+				if math.IsNaN(q.GetValue()) {
+					continue
+				}
+				qName := name + ".quantile." + sanitize(formatValue(q.GetQuantile()))
+				lines = append(lines, qName+":"+formatValue(q.GetValue())+"|g")
+			}
+		}
+	}
+	return lines
+}
+
+// appendBucket adds a timer line for the observations that fell into a
+// bucket since the previous export, reported at value
+func (e *Exporter) appendBucket(lines []string, name, le string, count, value float64) []string {
+	n := e.delta(name+".le."+le, count)
+	if n <= 0 {
+		return lines
+	}
+	line := name + ":" + formatValue(value) + "|ms"
+	if n > 1 {
+		line += "|@" + formatValue(1/n)
+	}
+	return append(lines, line)
+}
+
+// delta returns the increase of a cumulative value since the previous call
+// for the same key. A decrease (e.g. after a reset) is reported as the new
+// value.
+func (e *Exporter) delta(key string, value float64) float64 {
+	prev, seen := e.last[key]
+	e.last[key] = value
+	if !seen || value < prev {
+		return value
+	}
+	return value - prev
+}
+
+func (e *Exporter) metricName(name string, labels []*dto.LabelPair) string {
+	var b strings.Builder
+	if e.cfg.Prefix != "" {
+		b.WriteString(e.cfg.Prefix)
+		b.WriteByte('.')
+	}
+	b.WriteString(sanitize(name))
+	for _, lp := range labels {
+		b.WriteByte('.')
+		b.WriteString(sanitize(lp.GetName()))
+		b.WriteByte('.')
+		b.WriteString(sanitize(lp.GetValue()))
+	}
+	return b.String()
+}
+
+// send writes the lines in as few datagrams as possible
+func (e *Exporter) send(lines []string) error {
+	var packet bytes.Buffer
+	var firstErr error
+
+	flush := func() {
+		if packet.Len() == 0 {
+			return
+		}
+		if _, err := e.conn.Write(packet.Bytes()); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		packet.Reset()
+	}
+
+	for _, line := range lines {
+		if packet.Len() > 0 && packet.Len()+1+len(line) > maxPacketSize {
+			flush()
+		}
+		if packet.Len() > 0 {
+			packet.WriteByte('\n')
+		}
+		packet.WriteString(line)
+	}
+	flush()
+
+	return firstErr
+}
+
+// sanitize replaces characters with a meaning in the StatsD line protocol
+func sanitize(s string) string {
+	if s == "" {
+		return "_"
+	}
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '-':
+			return r
+		default:
+			return '_'
+		}
+	}, s)
+}
+
+func formatValue(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
@@ -0,0 +1,78 @@
+package statsd
+
+import (
+	"net"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// newTestExporter creates an exporter sending to a local UDP listener
+func newTestExporter(t *testing.T, reg *prometheus.Registry) (*Exporter, net.PacketConn) {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	e, err := NewExporter(Config{Address: conn.LocalAddr().String(), Gatherer: reg, Interval: time.Hour})
+	if err != nil {
+		t.Fatalf("NewExporter: %v", err)
+	}
+	return e, conn
+}
+
+func TestStopWithoutStart(t *testing.T) {
+	e, _ := newTestExporter(t, prometheus.NewRegistry())
+
+	stopped := make(chan struct{})
+	go func() {
+		e.Stop()
+		e.Stop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stop blocked without Start")
+	}
+}
+
+func TestStartStop(t *testing.T) {
+	e, _ := newTestExporter(t, prometheus.NewRegistry())
+	e.Start()
+	e.Start()
+	e.Stop()
+}
+
+func TestHistogramOverflowIsEmitted(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	h := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "size", Help: "size", Buckets: []float64{1, 10}})
+	reg.MustRegister(h)
+	h.Observe(0.5)
+	h.Observe(50)
+	h.Observe(100)
+
+	e, conn := newTestExporter(t, reg)
+	if err := e.Export(); err != nil {
+		t.Fatalf("Export: %v", err)
+	}
+
+	buf := make([]byte, maxPacketSize)
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+
+	got := strings.Split(string(buf[:n]), "\n")
+	want := []string{"size:1|ms", "size:10|ms|@0.5"}
+	if !slices.Equal(got, want) {
+		t.Errorf("lines = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,115 @@
+package prommonitoring
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// TimeoutMiddleware runs next with a deadline of d, like
+// http.TimeoutHandler, but answers 504 Gateway Timeout rather than 503
+// when the deadline passes, and counts it in
+// http_request_timeouts_total with Config.CountTimeouts.
+//
+// The handler writes into a buffer that is copied to the client only if
+// it finishes in time, so Flush is not supported. On timeout nothing of
+// the buffered response is sent. Wrap TimeoutMiddleware with Middleware
+// so the request is recorded with the status and size actually sent: 504
+// and the length of the timeout message.
+func (m *Metrics) TimeoutMiddleware(d time.Duration, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), d)
+		defer cancel()
+
+		tw := &timeoutWriter{header: make(http.Header)}
+		done := make(chan struct{})
+		panicked := make(chan any, 1)
+		go func() {
+			defer func() {
+				if p := recover(); p != nil {
+					panicked <- p
+				}
+			}()
+			next.ServeHTTP(tw, r.WithContext(ctx))
+			close(done)
+		}()
+
+		select {
+		case p := <-panicked:
+			panic(p)
+		case <-done:
+			tw.mu.Lock()
+			defer tw.mu.Unlock()
+			tw.copyTo(w)
+		case <-ctx.Done():
+			tw.mu.Lock()
+			defer tw.mu.Unlock()
+			tw.timedOut = true
+			if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				// The client went away, there is nobody to answer
+				return
+			}
+			if m.RequestTimeouts != nil {
+				info := &requestInfo{request: r, method: r.Method, path: m.pathLabel(r)}
+				m.RequestTimeouts.WithLabelValues(m.labelValues(m.routeLabels, info)...).Inc()
+			}
+			http.Error(w, http.StatusText(http.StatusGatewayTimeout), http.StatusGatewayTimeout)
+		}
+	})
+}
+
+// timeoutWriter buffers the response of a handler running under
+// TimeoutMiddleware. Writes after the timeout fail with
+// http.ErrHandlerTimeout.
+type timeoutWriter struct {
+	header http.Header
+
+	mu          sync.Mutex
+	buf         bytes.Buffer
+	code        int
+	wroteHeader bool
+	timedOut    bool
+}
+
+func (tw *timeoutWriter) Header() http.Header {
+	return tw.header
+}
+
+func (tw *timeoutWriter) WriteHeader(code int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut || tw.wroteHeader {
+		return
+	}
+	tw.wroteHeader = true
+	tw.code = code
+}
+
+func (tw *timeoutWriter) Write(p []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	if !tw.wroteHeader {
+		tw.wroteHeader = true
+		tw.code = http.StatusOK
+	}
+	return tw.buf.Write(p)
+}
+
+// copyTo sends the buffered response
+func (tw *timeoutWriter) copyTo(w http.ResponseWriter) {
+	dst := w.Header()
+	for key, values := range tw.header {
+		dst[key] = values
+	}
+	if !tw.wroteHeader {
+		tw.code = http.StatusOK
+	}
+	w.WriteHeader(tw.code)
+	_, _ = w.Write(tw.buf.Bytes())
+}
@@ -0,0 +1,84 @@
+package prommonitoring
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// slowBody yields one byte per read, sleeping before each
+type slowBody struct {
+	remaining int
+	delay     time.Duration
+}
+
+func (b *slowBody) Read(p []byte) (int, error) {
+	if b.remaining == 0 {
+		return 0, io.EOF
+	}
+	time.Sleep(b.delay)
+	b.remaining--
+	p[0] = 'x'
+	return 1, nil
+}
+
+func TestTimeoutMiddlewareHandlerExceedsTimeout(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.CountTimeouts = true
+	cfg.MeasureBodyReadTime = true
+	cfg.RequestSizeFromBody = true
+	cfg.CountBodyReadErrors = true
+	m, reg := newTestMetrics(t, cfg)
+
+	handlerDone := make(chan struct{})
+	slow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer close(handlerDone)
+		// Keeps reading long after the deadline, ignoring the context
+		_, _ = io.Copy(io.Discard, r.Body)
+		_, _ = w.Write([]byte("too late"))
+	})
+	handler := m.Middleware(m.TimeoutMiddleware(20*time.Millisecond, slow))
+
+	req := httptest.NewRequest(http.MethodPost, "/upload", io.NopCloser(&slowBody{remaining: 20, delay: 5 * time.Millisecond}))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusGatewayTimeout)
+	}
+	if got := counterValue(t, reg, "app_http_request_timeouts_total", map[string]string{"path": "/upload"}); got != 1 {
+		t.Errorf("timeouts = %v, want 1", got)
+	}
+	if got := counterValue(t, reg, "app_http_requests_total", map[string]string{"path": "/upload", "status": "504"}); got != 1 {
+		t.Errorf("requests with status 504 = %v, want 1", got)
+	}
+
+	select {
+	case <-handlerDone:
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler never returned")
+	}
+}
+
+func TestTimeoutMiddlewareHandlerInTime(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.CountTimeouts = true
+	m, reg := newTestMetrics(t, cfg)
+
+	handler := m.Middleware(m.TimeoutMiddleware(time.Second, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte("ok"))
+	})))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/fast", nil))
+
+	if rec.Code != http.StatusCreated || rec.Body.String() != "ok" {
+		t.Fatalf("response = %d %q, want 201 \"ok\"", rec.Code, rec.Body.String())
+	}
+	if got := counterValue(t, reg, "app_http_request_timeouts_total", nil); got != 0 {
+		t.Errorf("timeouts = %v, want 0", got)
+	}
+}
@@ -0,0 +1,64 @@
+package prommonitoring
+
+import (
+	"bytes"
+	"log"
+	"strings"
+)
+
+// tlsHandshakeErrorPrefix starts the message net/http logs for every
+// failed TLS handshake. Failed handshakes never reach a handler, and the
+// error log is the only place the server reports them.
+const tlsHandshakeErrorPrefix = "http: TLS handshake error from "
+
+// tlsErrorReasons classifies handshake errors into the bounded reason
+// label by substrings of the error text. The first match wins.
+var tlsErrorReasons = []struct {
+	reason     string
+	substrings []string
+}{
+	{"http_request", []string{"client sent an HTTP request to an HTTPS server"}},
+	{"timeout", []string{"timeout"}},
+	{"eof", []string{"EOF"}},
+	{"reset", []string{"connection reset"}},
+	{"certificate", []string{"certificate"}},
+	{"protocol_mismatch", []string{"cipher", "version", "protocol", "ALPN"}},
+}
+
+// tlsErrorReason returns the reason label of a handshake error message
+func tlsErrorReason(msg string) string {
+	for _, r := range tlsErrorReasons {
+		for _, substring := range r.substrings {
+			if strings.Contains(msg, substring) {
+				return r.reason
+			}
+		}
+	}
+	return "other"
+}
+
+// tlsErrorLogWriter counts the handshake errors in the server error log
+// and forwards every line to the previous logger
+type tlsErrorLogWriter struct {
+	m        *Metrics
+	previous *log.Logger
+}
+
+func (w *tlsErrorLogWriter) Write(p []byte) (int, error) {
+	msg := string(bytes.TrimRight(p, "\n"))
+	if _, rest, ok := strings.Cut(msg, tlsHandshakeErrorPrefix); ok {
+		w.m.TLSHandshakeErrors.WithLabelValues(tlsErrorReason(rest)).Inc()
+	}
+	if w.previous != nil {
+		w.previous.Print(msg)
+	} else {
+		log.Print(msg)
+	}
+	return len(p), nil
+}
+
+// instrumentErrorLog replaces the error log with one counting handshake
+// errors
+func (m *Metrics) instrumentErrorLog(previous *log.Logger) *log.Logger {
+	return log.New(&tlsErrorLogWriter{m: m, previous: previous}, "", 0)
+}
@@ -0,0 +1,111 @@
+package prommonitoring
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// TraceparentHeader is the W3C Trace Context propagation header
+const TraceparentHeader = "traceparent"
+
+type traceContextKey struct{}
+
+type traceContext struct {
+	traceID string
+	spanID  string
+}
+
+// TraceIDFromContext returns the trace ID stored by TraceparentMiddleware
+func TraceIDFromContext(ctx context.Context) (string, bool) {
+	tc, ok := ctx.Value(traceContextKey{}).(traceContext)
+	return tc.traceID, ok
+}
+
+// TraceExemplar is an ExemplarFromContext hook that attaches the trace ID
+// extracted by TraceparentMiddleware as a "trace_id" exemplar label
+func TraceExemplar(ctx context.Context) prometheus.Labels {
+	if id, ok := TraceIDFromContext(ctx); ok {
+		return prometheus.Labels{"trace_id": id}
+	}
+	return nil
+}
+
+// TraceparentMiddleware parses an incoming W3C traceparent header and
+// stores the trace ID in the request context, where TraceExemplar can pick
+// it up. It must wrap Middleware for the exemplars to be recorded.
+//
+// When the header is missing or invalid and Config.GenerateTraceparent is
+// set, a new trace is started and the header is set on the request so it
+// propagates to downstream calls.
+func (m *Metrics) TraceparentMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tc, ok := parseTraceparent(r.Header.Get(TraceparentHeader))
+		if !ok && m.cfg.GenerateTraceparent {
+			tc = traceContext{traceID: randomHex(16), spanID: randomHex(8)}
+			r.Header.Set(TraceparentHeader, "00-"+tc.traceID+"-"+tc.spanID+"-01")
+			ok = true
+		}
+
+		if ok {
+			r = r.WithContext(context.WithValue(r.Context(), traceContextKey{}, tc))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// parseTraceparent parses a version-00 traceparent header:
+//
+//	version "-" trace-id "-" parent-id "-" trace-flags
+//
+// Headers of a higher version are accepted as long as their version-00
+// prefix is valid, as required by the specification.
+func parseTraceparent(h string) (traceContext, bool) {
+	if len(h) < 55 || (len(h) > 55 && h[55] != '-') {
+		return traceContext{}, false
+	}
+	if h[2] != '-' || h[35] != '-' || h[52] != '-' {
+		return traceContext{}, false
+	}
+
+	version, traceID, spanID, flags := h[0:2], h[3:35], h[36:52], h[53:55]
+	if !isLowerHex(version) || version == "ff" || (version == "00" && len(h) != 55) {
+		return traceContext{}, false
+	}
+	if !isLowerHex(traceID) || !isLowerHex(spanID) || !isLowerHex(flags) {
+		return traceContext{}, false
+	}
+	if isZeros(traceID) || isZeros(spanID) {
+		return traceContext{}, false
+	}
+
+	return traceContext{traceID: traceID, spanID: spanID}, true
+}
+
+func isLowerHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func isZeros(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] != '0' {
+			return false
+		}
+	}
+	return true
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}